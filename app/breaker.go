@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultFailureBackoff is the first backoff for targets without a sync_interval
+const defaultFailureBackoff = time.Minute
+
+// circuitBreaker counts consecutive failed syncs of a target. Once they reach
+// failure_threshold it opens, and syncs are skipped for a backoff that starts at
+// one sync_interval and doubles with every further failure, up to
+// max_failure_backoff. A successful sync closes it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a sync may start now, and if not, when it may
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.openUntil), b.openUntil
+}
+
+// record adds a sync's outcome and reports whether it opened or closed the breaker.
+// A threshold of zero disables the breaker.
+func (b *circuitBreaker) record(err error, threshold int, interval, maxBackoff time.Duration, now time.Time) (opened, closed bool, backoff time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		closed = threshold > 0 && b.failures >= threshold
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return false, closed, 0
+	}
+
+	b.failures++
+	if threshold == 0 || b.failures < threshold {
+		return false, false, 0
+	}
+	backoff = interval
+	if backoff <= 0 {
+		backoff = defaultFailureBackoff
+	}
+	for i := threshold; i < b.failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	b.openUntil = now.Add(backoff)
+	return b.failures == threshold, false, backoff
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/notmaurox/syncd"
+)
+
+// Exit codes returned from main so cron, CI and supervisors such as systemd can
+// tell how a one-time sync went
+const (
+	exitOK = 0
+	// exitFatal means bad config, credentials or permissions; restarting won't help
+	exitFatal = 1
+	// exitPartial means the sync finished but some files failed (continue_on_error)
+	exitPartial = 2
+	// exitSyncFailed means the sync failed, e.g. on a network error
+	exitSyncFailed = 3
+)
+
+// errLockHeld means another instance holds the lock on LocalDir
+var errLockHeld = errors.New("lock is held by another process")
+
+// exitCodeFor maps a sync outcome to the process exit code
+func exitCodeFor(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case syncd.IsFatal(err):
+		return exitFatal
+	case syncd.IsPartial(err):
+		return exitPartial
+	default:
+		return exitSyncFailed
+	}
+}
+
+// exitCodeForAll returns the most severe exit code among the targets' outcomes
+func exitCodeForAll(errs []error) int {
+	codes := make(map[int]bool)
+	for _, err := range errs {
+		codes[exitCodeFor(err)] = true
+	}
+	for _, code := range []int{exitFatal, exitSyncFailed, exitPartial} {
+		if codes[code] {
+			return code
+		}
+	}
+	return exitOK
+}
+
+// fatal logs msg at error level and exits with exitFatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(exitFatal)
+}
@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// syncGuard ensures at most one sync runs at a time and lets shutdown wait
+// for the running sync to finish.
+type syncGuard struct {
+	inProgress chan struct{}
+	wg         sync.WaitGroup
+}
+
+func newSyncGuard() *syncGuard {
+	return &syncGuard{inProgress: make(chan struct{}, 1)}
+}
+
+// TryStart claims the guard for a new sync. It returns false without blocking
+// if a sync is already running. Every successful TryStart must be paired with Finish.
+func (g *syncGuard) TryStart() bool {
+	select {
+	case g.inProgress <- struct{}{}:
+		g.wg.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// Finish releases the guard claimed by TryStart
+func (g *syncGuard) Finish() {
+	<-g.inProgress
+	g.wg.Done()
+}
+
+// Wait blocks until the running sync, if any, has called Finish.
+// Once it returns the guard is free again, so it is safe to reuse after a reload.
+func (g *syncGuard) Wait() {
+	g.wg.Wait()
+}
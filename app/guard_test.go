@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncGuardSkipsOverlappingSyncs(t *testing.T) {
+	guard := newSyncGuard()
+	if !guard.TryStart() {
+		t.Fatal("TryStart on a free guard returned false")
+	}
+
+	// Ticks that fire while the sync runs are all skipped
+	var started atomic.Int32
+	var ticks sync.WaitGroup
+	for range 10 {
+		ticks.Add(1)
+		go func() {
+			defer ticks.Done()
+			if guard.TryStart() {
+				started.Add(1)
+			}
+		}()
+	}
+	ticks.Wait()
+	if n := started.Load(); n != 0 {
+		t.Fatalf("%d overlapping ticks started a sync, want 0", n)
+	}
+
+	guard.Finish()
+	if !guard.TryStart() {
+		t.Fatal("TryStart after Finish returned false")
+	}
+	guard.Finish()
+}
+
+func TestSyncGuardWaitsForRunningSync(t *testing.T) {
+	guard := newSyncGuard()
+
+	// Nothing running: Wait returns immediately
+	guard.Wait()
+
+	if !guard.TryStart() {
+		t.Fatal("TryStart on a free guard returned false")
+	}
+	done := make(chan struct{})
+	go func() {
+		guard.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Wait returned while the sync was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	guard.Finish()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait didn't return after the sync finished")
+	}
+
+	// The guard is free again once Wait returns
+	if !guard.TryStart() {
+		t.Fatal("TryStart after Wait returned false")
+	}
+	guard.Finish()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// healthState tracks sync outcomes for the /healthz and /readyz probes
+type healthState struct {
+	mu      sync.Mutex
+	targets int              // targets that must finish a sync before the process is ready
+	lastErr map[string]error // outcome of each target's most recent sync
+}
+
+func newHealthState(targets int) *healthState {
+	return &healthState{targets: targets, lastErr: make(map[string]error)}
+}
+
+// Record stores the outcome of a target's finished sync
+func (h *healthState) Record(target string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr[target] = err
+}
+
+// register adds the probe handlers to mux. /healthz succeeds while the process
+// is serving; /readyz only once every target has finished a sync and the last
+// one of each succeeded.
+func (h *healthState) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if len(h.lastErr) < h.targets {
+			http.Error(w, "initial sync has not completed", http.StatusServiceUnavailable)
+			return
+		}
+		for target, err := range h.lastErr {
+			if err != nil {
+				if target != "" {
+					err = fmt.Errorf("target %s: %w", target, err)
+				}
+				http.Error(w, fmt.Sprintf("last sync failed: %v", err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// acquireLock only creates the lock file on platforms without flock, so running
+// two instances against the same directory isn't prevented there
+func acquireLock(path string) (*os.File, error) {
+	slog.Warn("Instance locking isn't supported on this platform")
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+}
@@ -2,110 +2,306 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/notmaurox/syncd"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	deleteFrom := flag.String("delete-from", "", "delete the newline-separated relative paths in this file from S3 instead of syncing")
+	dryRun := flag.Bool("dry-run", false, "log planned uploads and deletes without modifying the bucket (same as dry_run=true)")
+	once := flag.Bool("once", false, "sync once and exit, ignoring sync_interval, schedule and watch")
+	diff := flag.Bool("diff", false, "list files that would be uploaded or re-uploaded and objects with no local file, without syncing")
+	jsonOutput := flag.Bool("json", false, "with --diff, write the report as JSON")
+	pull := flag.Bool("pull", false, "download every object under the prefix into local_dir, e.g. to restore into an empty directory, instead of syncing")
+	showVersion := flag.Bool("version", false, "print the version, git commit and build date and exit")
+	progress := flag.Bool("progress", false, "show a single updating progress line on stderr during uploads instead of per-file logs")
+	verbose := flag.Bool("verbose", false, "log at debug level, including per-file lines with --progress")
+	flag.Parse()
+	args := flag.Args()
+
+	// Report which build is deployed
+	if *showVersion || (len(args) == 1 && args[0] == "version") {
+		printVersion(os.Stdout)
+		return
+	}
+
 	// Check if config file path is provided
-	if len(os.Args) < 2 {
-		log.Fatal("Please provide path to config file")
+	if len(args) < 1 {
+		fatal("Please provide path to config file")
 	}
 
-	configFilePath := os.Args[1]
+	// Compare two config files instead of syncing
+	if args[0] == "diff-config" {
+		if len(args) != 3 {
+			fatal("Usage: syncd diff-config <config-a> <config-b>")
+		}
+		if err := syncd.DiffConfigFiles(os.Stdout, args[1], args[2]); err != nil {
+			fatal("Error comparing configs", "err", err)
+		}
+		return
+	}
 
-	// Read configuration from file
-	config, err := readConfigFile(configFilePath)
-	if err != nil {
-		log.Fatalf("Error reading config: %v", err)
+	// Estimate the cost of a sync instead of running it
+	planOnly := args[0] == "plan"
+	if planOnly {
+		if len(args) != 2 {
+			fatal("Usage: syncd plan <config>")
+		}
+		args = args[1:]
 	}
 
-	// Load AWS configuration with credentials
-	awsConfig, err := loadAWSConfig(config)
+	configFilePath := args[0]
+
+	// Route log output through RunLog so sync runs can be captured
+	log.SetOutput(syncd.RunLog)
+
+	// Read configuration from file; each [target] section is synced independently
+	targets, err := syncd.ReadConfigTargets(configFilePath)
 	if err != nil {
-		log.Fatalf("Unable to load AWS config: %v", err)
+		fatal("Error reading config", "err", err)
+	}
+	// Logging, metrics and health checks are process-wide, so they come from the
+	// first target (set them above the first section to share them)
+	config := targets[0]
+	// Keep log lines from breaking up the progress line unless asked for
+	if *verbose {
+		config.LogLevel = slog.LevelDebug
+	} else if *progress {
+		config.LogLevel = max(config.LogLevel, slog.LevelWarn)
+	}
+	slog.SetDefault(syncd.NewLogger(syncd.RunLog, config))
+	// Command-line overrides, also applied to configs reloaded on SIGHUP
+	adjust := func(target *syncd.SyncConfig) {
+		target.DryRun = target.DryRun || *dryRun
+		// A one-time run, e.g. triggered by cron, regardless of how the daemon is configured
+		if *once {
+			target.SyncInterval = 0
+			target.Schedule = ""
+			target.Watch = false
+		}
+	}
+	for _, target := range targets {
+		adjust(target)
+	}
+
+	// A pull restores into local_dir, which may be empty or not exist yet
+	if *pull {
+		for _, target := range targets {
+			target.AllowEmpty = true
+			if err := os.MkdirAll(target.LocalDir, 0o755); err != nil {
+				fatal("Unable to create local_dir", "dir", target.LocalDir, "err", err)
+			}
+		}
 	}
 
-	// Create S3 client
-	client := s3.NewFromConfig(awsConfig)
+	// Create a context that is canceled on SIGINT/SIGTERM. Syncs stop after
+	// the file they are currently uploading; multipart uploads are aborted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Create a context that we can cancel
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	syncers := make([]*syncd.Syncer, len(targets))
+	for i, target := range targets {
+		syncers[i] = newTargetSyncer(ctx, target)
+		if *progress {
+			syncers[i].SetProgress(os.Stderr)
+		}
+	}
 
-	// Use a WaitGroup to track running syncs
-	var wg sync.WaitGroup
+	if planOnly {
+		for i, target := range targets {
+			if target.Name != "" {
+				fmt.Printf("Target %s:\n", target.Name)
+			}
+			if err := syncers[i].Plan(ctx, os.Stdout); err != nil {
+				fatal("Plan failed", "target", target.Name, "err", err)
+			}
+		}
+		return
+	}
 
-	// Create a channel to signal when a sync is in progress
-	inProgress := make(chan struct{}, 1)
-
-	// Perform initial sync
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := performFullSync(ctx, client, config); err != nil {
-			log.Printf("Initial sync failed: %v", err)
-		}
-	}()
-
-	// If sync interval is specified, start periodic syncing
-	if config.SyncInterval > 0 {
-		ticker := time.NewTicker(config.SyncInterval)
-		defer ticker.Stop()
-
-		log.Printf("Starting periodic sync every %v", config.SyncInterval)
-
-		for {
-			select {
-			case <-ticker.C:
-				// Try to acquire the inProgress channel
-				select {
-				case inProgress <- struct{}{}:
-					// Successfully acquired the channel, start sync
-					wg.Add(1)
-					go func() {
-						defer wg.Done()
-						defer func() { <-inProgress }() // Release the inProgress channel when done
-
-						log.Printf("Starting scheduled sync")
-						if err := performFullSync(ctx, client, config); err != nil {
-							log.Printf("Periodic sync failed: %v", err)
-						}
-					}()
-				default:
-					// A sync is already in progress
-					log.Printf("Previous sync still in progress, skipping this interval")
+	// Report differences instead of syncing
+	if *diff {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		for i, target := range targets {
+			report, err := syncers[i].Diff(ctx)
+			if err != nil {
+				fatal("Diff failed", "target", target.Name, "err", err)
+			}
+			if *jsonOutput {
+				if err := encoder.Encode(report); err != nil {
+					fatal("Unable to write diff", "err", err)
 				}
-			case <-ctx.Done():
-				// Wait for any running syncs to complete
-				wg.Wait()
-				return
+				continue
+			}
+			if target.Name != "" {
+				fmt.Printf("Target %s:\n", target.Name)
+			}
+			report.WriteText(os.Stdout)
+		}
+		return
+	}
+
+	// Refuse to run alongside another instance syncing the same directory. Syncs
+	// that download may start with no local_dir, so create it to hold the lock.
+	for _, target := range targets {
+		if target.Downloads() {
+			if err := os.MkdirAll(target.LocalDir, 0o755); err != nil {
+				fatal("Unable to create local_dir", "dir", target.LocalDir, "err", err)
+			}
+		}
+		lock, err := acquireLock(filepath.Join(target.LocalDir, syncd.LockFileName))
+		if errors.Is(err, errLockHeld) {
+			fatal("Another syncd instance is already running against local_dir", "dir", target.LocalDir, "lock", syncd.LockFileName)
+		}
+		if err != nil {
+			fatal("Unable to create lock file", "dir", target.LocalDir, "err", err)
+		}
+		defer lock.Close()
+	}
+
+	// Targeted cleanup of an explicit key list instead of a sync
+	if *deleteFrom != "" {
+		if len(targets) > 1 {
+			fatal("--delete-from needs a config with a single target")
+		}
+		if err := syncers[0].DeleteFromFile(ctx, *deleteFrom); err != nil {
+			fatal("Delete failed", "err", err)
+		}
+		return
+	}
+
+	// Clean up multipart uploads left behind by earlier runs that were killed
+	for i, target := range targets {
+		if target.AbortStaleMultiparts == 0 {
+			continue
+		}
+		if err := syncers[i].AbortStaleMultiparts(ctx); err != nil {
+			slog.Warn("Unable to clean up stale multipart uploads", "target", target.Name, "err", err)
+		}
+	}
+
+	// Restore the remote copy instead of syncing
+	if *pull {
+		errs := make([]error, len(targets))
+		for i, target := range targets {
+			if errs[i] = syncers[i].Pull(ctx); errs[i] != nil {
+				slog.Error("Pull failed", "target", target.Name, "err", errs[i])
 			}
 		}
+		if code := exitCodeForAll(errs); code != exitOK {
+			os.Exit(code)
+		}
+		return
+	}
+
+	// Expose sync metrics for Prometheus and liveness/readiness probes. They
+	// share one server when both use the same address.
+	health := newHealthState(len(targets))
+	muxes := make(map[string]*http.ServeMux)
+	muxFor := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if config.MetricsAddr != "" {
+		muxFor(config.MetricsAddr).Handle("/metrics", promhttp.Handler())
+		slog.Info("Serving metrics", "addr", config.MetricsAddr, "path", "/metrics")
+	}
+	if config.HealthAddr != "" {
+		health.register(muxFor(config.HealthAddr))
+		slog.Info("Serving health checks", "addr", config.HealthAddr, "paths", "/healthz,/readyz")
+	}
+	for addr, mux := range muxes {
+		if err := serveHTTP(ctx, addr, mux); err != nil {
+			fatal("Unable to listen", "addr", addr, "err", err)
+		}
 	}
 
-	// Wait for the initial sync to complete if no interval was specified
+	// Reload the config on SIGHUP without dropping the process
+	reloaded := make([]chan struct{}, len(targets))
+	for i := range reloaded {
+		reloaded[i] = make(chan struct{}, 1)
+	}
+	reloadOnHangup(ctx, configFilePath, syncers, reloaded, adjust)
+
+	// Run every target until shutdown, or until their one-time syncs finish
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = runTarget(ctx, syncers[i], health, reloaded[i])
+		}()
+	}
 	wg.Wait()
+
+	// Report how one-time syncs went to cron, CI or the supervisor
+	if code := exitCodeForAll(errs); code != exitOK {
+		os.Exit(code)
+	}
+}
+
+// newTargetSyncer builds the S3 client for a target and checks that its
+// credentials and bucket work, exiting if they don't
+func newTargetSyncer(ctx context.Context, cfg *syncd.SyncConfig) *syncd.Syncer {
+	// Load AWS configuration with credentials
+	awsConfig, err := syncd.LoadAWSConfig(cfg)
+	if err != nil {
+		fatal("Unable to load AWS config", "target", cfg.Name, "err", err)
+	}
+
+	// Create S3 client, using path-style addressing for S3-compatible stores that need it.
+	// Requests are retried by syncd itself (max_retries), so the SDK makes a single
+	// attempt; otherwise its own retries would multiply with ours.
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = cfg.UsePathStyle
+		o.RetryMaxAttempts = 1
+	})
+
+	// Fail fast on bad credentials or an inaccessible bucket
+	if err := syncd.Preflight(ctx, awsConfig, client, cfg); err != nil {
+		slog.Error("Preflight check failed", "target", cfg.Name, "err", err)
+		os.Exit(exitCodeFor(err))
+	}
+	return syncd.NewSyncer(client, cfg)
 }
 
-// Separate function to load AWS config with provided credentials
-func loadAWSConfig(cfg *SyncConfig) (aws.Config, error) {
-	// Create static credentials
-	staticCredProvider := credentials.NewStaticCredentialsProvider(
-		cfg.AWSAccessKey,
-		cfg.AWSSecretKey,
-		"",
-	)
-
-	// Load default config and override with static credentials
-	return config.LoadDefaultConfig(context.TODO(),
-		config.WithCredentialsProvider(staticCredProvider),
-	)
+// performSyncWithRetries runs a full sync, retrying the whole sync with
+// exponential backoff up to cfg.SyncRetries times before giving up.
+// The result is from the last attempt.
+func performSyncWithRetries(ctx context.Context, syncer *syncd.Syncer, cfg *syncd.SyncConfig) (syncd.SyncResult, error) {
+	backoff := cfg.SyncRetryBackoff
+	for attempt := 0; ; attempt++ {
+		result, err := syncer.Sync(ctx)
+		// Config and auth failures won't fix themselves, so don't retry them
+		if err == nil || syncd.IsFatal(err) || attempt >= cfg.SyncRetries {
+			return result, err
+		}
+
+		slog.Warn("Sync attempt failed, retrying", "attempt", attempt+1, "attempts", cfg.SyncRetries+1, "err", err, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return result, err
+		}
+		backoff *= 2
+	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/notmaurox/syncd"
+)
+
+// reloadOnHangup re-reads the config at configPath whenever the process gets SIGHUP
+// until ctx is canceled. adjust applies command-line overrides to each reloaded target.
+func reloadOnHangup(ctx context.Context, configPath string, syncers []*syncd.Syncer, reloaded []chan struct{}, adjust func(*syncd.SyncConfig)) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-hangup:
+				reloadConfig(configPath, syncers, reloaded, adjust)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reloadConfig swaps the settings in configPath into each running target, matched by
+// name, and signals the target's reloaded channel so it can pick up a new sync_interval.
+// If the config doesn't load, every target keeps running with its current settings.
+func reloadConfig(configPath string, syncers []*syncd.Syncer, reloaded []chan struct{}, adjust func(*syncd.SyncConfig)) {
+	if configPath == "-" {
+		slog.Warn("Config was read from stdin, ignoring SIGHUP")
+		return
+	}
+	targets, err := syncd.ReadConfigTargets(configPath)
+	if err != nil {
+		slog.Error("Config reload failed, keeping the running config", "err", err)
+		return
+	}
+
+	byName := make(map[string]*syncd.SyncConfig, len(targets))
+	for _, target := range targets {
+		adjust(target)
+		byName[target.Name] = target
+	}
+	for i, syncer := range syncers {
+		running := syncer.Config()
+		next, exists := byName[running.Name]
+		if !exists {
+			slog.Warn("Target missing from reloaded config, keeping its running config", "target", running.Name)
+			continue
+		}
+		delete(byName, running.Name)
+
+		if ignored := syncd.RetainRestartOnly(running, next); len(ignored) > 0 {
+			slog.Warn("Ignoring config changes that need a restart", "target", running.Name, "fields", strings.Join(ignored, ","))
+		}
+		syncer.SetConfig(next)
+		select {
+		case reloaded[i] <- struct{}{}:
+		default:
+		}
+	}
+	for name := range byName {
+		slog.Warn("Ignoring new target until restart", "target", name)
+	}
+	slog.Info("Reloaded config", "path", configPath)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/notmaurox/syncd"
+	"github.com/robfig/cron/v3"
+)
+
+// runTarget syncs one target on its own schedule until ctx is canceled, or once
+// when it has no schedule, interval or watch. Each target has its own guard, so
+// a slow target never delays another. Each sync uses the syncer's current config;
+// a signal on reloaded picks up a changed sync_interval. It returns the outcome
+// of a one-time sync.
+func runTarget(ctx context.Context, syncer *syncd.Syncer, health *healthState, reloaded <-chan struct{}) error {
+	cfg := syncer.Config()
+	logger := slog.Default()
+	if cfg.Name != "" {
+		logger = logger.With("target", cfg.Name)
+	}
+
+	// Guard against overlapping syncs
+	guard := newSyncGuard()
+
+	// Outcome of the most recent sync, read after guard.Wait() to pick the exit code
+	var lastErr error
+
+	// Files written by downloads, so watch mode doesn't sync again on their events
+	downloads := newDownloadedFiles()
+	if cfg.Watch {
+		syncer.SetDownloadHook(downloads.add)
+	}
+
+	// Backs off from a degraded endpoint after repeated failures (failure_threshold)
+	breaker := &circuitBreaker{}
+
+	// startSync runs a sync in the background unless one is already in progress,
+	// and reports whether it started one
+	startSync := func(name string) bool {
+		if allowed, until := breaker.allow(time.Now()); !allowed {
+			logger.Debug("Backing off after repeated failures, skipping this sync", "trigger", name, "until", until)
+			return false
+		}
+		if !guard.TryStart() {
+			logger.Info("Previous sync still in progress, skipping this sync", "trigger", name)
+			return false
+		}
+		go func() {
+			defer guard.Finish()
+
+			logger.Info("Starting sync", "trigger", name)
+			var result syncd.SyncResult
+			result, lastErr = performSyncWithRetries(ctx, syncer, syncer.Config())
+			health.Record(cfg.Name, lastErr)
+			logger.Info("Sync summary", "trigger", name, "uploaded", result.FilesUploaded,
+				"deleted", result.FilesDeleted, "skipped", result.FilesSkipped, "bytes", result.BytesUploaded,
+				"errors", len(result.Errors), "duration", result.Duration.Round(time.Millisecond))
+			if lastErr != nil {
+				logger.Error("Sync failed", "trigger", name, "err", lastErr)
+			}
+
+			current := syncer.Config()
+			opened, closed, backoff := breaker.record(lastErr, current.FailureThreshold, current.SyncInterval, current.MaxFailureBackoff, time.Now())
+			switch {
+			case opened:
+				syncd.RecordCircuitOpened()
+				logger.Warn("Circuit breaker opened, backing off after repeated failed syncs",
+					"failures", current.FailureThreshold, "backoff", backoff)
+			case closed:
+				syncd.RecordCircuitClosed()
+				logger.Info("Circuit breaker closed, sync succeeded")
+			case backoff > 0:
+				logger.Warn("Sync still failing, backing off", "backoff", backoff)
+			}
+		}()
+		return true
+	}
+
+	// Perform initial sync
+	startSync("initial")
+
+	// With a cron schedule, sync at the scheduled times until shutdown
+	if cfg.Schedule != "" {
+		scheduler := cron.New()
+		if _, err := scheduler.AddFunc(cfg.Schedule, func() { startSync("scheduled") }); err != nil {
+			fatal("Invalid schedule", "err", err)
+		}
+		scheduler.Start()
+		logger.Info("Syncing on schedule", "schedule", cfg.Schedule)
+
+		<-ctx.Done()
+		<-scheduler.Stop().Done()
+		logger.Info("Shutting down, waiting for active sync")
+		guard.Wait()
+		return nil
+	}
+
+	// In watch mode, sync on filesystem changes instead of a fixed interval
+	if cfg.Watch {
+		if err := watchAndSync(ctx, cfg, downloads, startSync); err != nil {
+			fatal("Unable to watch local_dir", "dir", cfg.LocalDir, "err", err)
+		}
+		logger.Info("Shutting down, waiting for active sync")
+		guard.Wait()
+		return nil
+	}
+
+	// If sync interval is specified, start periodic syncing
+	if cfg.SyncInterval > 0 {
+		// A timer rescheduled after every tick, so each interval gets its own jitter
+		interval, jitter := cfg.SyncInterval, cfg.SyncJitter
+		timer := time.NewTimer(nextSyncDelay(interval, jitter))
+		defer timer.Stop()
+
+		logger.Info("Starting periodic sync", "interval", interval, "jitter", jitter)
+
+		for {
+			select {
+			case <-timer.C:
+				startSync("scheduled")
+				timer.Reset(nextSyncDelay(interval, jitter))
+			case <-reloaded:
+				next := syncer.Config()
+				if next.SyncInterval != interval || next.SyncJitter != jitter {
+					interval, jitter = next.SyncInterval, next.SyncJitter
+					timer.Reset(nextSyncDelay(interval, jitter))
+					logger.Info("Sync interval changed", "interval", interval, "jitter", jitter)
+				}
+			case <-ctx.Done():
+				// Wait for any running sync to complete
+				logger.Info("Shutting down, waiting for active sync")
+				guard.Wait()
+				return nil
+			}
+		}
+	}
+
+	// Wait for the initial sync to complete if no interval was specified
+	done := make(chan struct{})
+	go func() {
+		guard.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Info("Shutting down, waiting for active sync")
+		<-done
+	}
+	return lastErr
+}
+
+// nextSyncDelay returns interval shifted by a random amount of up to jitter either
+// way, so a fleet started together doesn't hit S3 on the same tick
+func nextSyncDelay(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return max(interval+time.Duration(rand.Int64N(int64(2*jitter)+1))-jitter, 0)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// printVersion writes the build metadata to w
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "syncd %s (commit %s, built %s)\n", version, commit, buildDate)
+}
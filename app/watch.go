@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/notmaurox/syncd"
+)
+
+// watchAndSync starts a sync whenever LocalDir changes, once events have been quiet
+// for cfg.WatchDebounce. Changes that arrive while a sync is running trigger another
+// sync after it finishes, except syncd's own temporary files and the files its
+// downloads wrote (see downloadedFiles). It returns when ctx is done.
+func watchAndSync(ctx context.Context, cfg *syncd.SyncConfig, downloads *downloadedFiles, startSync func(name string) bool) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// fsnotify isn't recursive, so every directory is watched individually
+	if err := watchTree(watcher, cfg.LocalDir); err != nil {
+		return err
+	}
+	slog.Info("Watching for changes", "dir", cfg.LocalDir)
+
+	debounce := time.NewTimer(cfg.WatchDebounce)
+	debounce.Stop()
+	// Paths with events since the last sync started
+	changed := make(map[string]bool)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Pick up directories created at runtime, along with anything already inside them
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, event.Name); err != nil {
+						slog.Error("Error watching directory", "dir", event.Name, "err", err)
+					}
+				}
+			}
+			if ignoreEvent(event) {
+				continue
+			}
+			changed[event.Name] = true
+			debounce.Reset(cfg.WatchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Error("Watch error", "err", err)
+		case <-debounce.C:
+			// Drop the writes of downloads, which are only known once each file is in place
+			for path := range changed {
+				if downloads.unchanged(path) {
+					delete(changed, path)
+				}
+			}
+			if len(changed) == 0 {
+				continue
+			}
+			// Try again later rather than dropping changes made during a running sync
+			if !startSync("watch") {
+				debounce.Reset(cfg.WatchDebounce)
+				continue
+			}
+			clear(changed)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// watchTree adds root and every directory below it to watcher
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		return watcher.Add(path)
+	})
+}
+
+// ignoreEvent reports whether event is for syncd's lock or temporary files
+func ignoreEvent(event fsnotify.Event) bool {
+	name := filepath.Base(event.Name)
+	return name == syncd.LockFileName || strings.HasPrefix(name, syncd.TempFilePrefix)
+}
+
+// downloadedFiles remembers the modification time of every file a sync downloaded,
+// so the watcher can drop the events of those writes. A file whose mtime no longer
+// matches has been changed locally since, and is forgotten.
+type downloadedFiles struct {
+	mu     sync.Mutex
+	mtimes map[string]time.Time
+}
+
+func newDownloadedFiles() *downloadedFiles {
+	return &downloadedFiles{mtimes: make(map[string]time.Time)}
+}
+
+// add records the file at path as just written by a download; it is the
+// Syncer's download hook
+func (d *downloadedFiles) add(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mtimes[path] = info.ModTime()
+}
+
+// unchanged reports whether path is still as a download left it
+func (d *downloadedFiles) unchanged(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mtime, downloaded := d.mtimes[path]
+	if !downloaded {
+		return false
+	}
+	if info, err := os.Lstat(path); err == nil && info.ModTime().Equal(mtime) {
+		return true
+	}
+	delete(d.mtimes, path)
+	return false
+}
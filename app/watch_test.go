@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/notmaurox/syncd"
+)
+
+func TestIgnoreEvent(t *testing.T) {
+	for name, want := range map[string]bool{
+		syncd.LockFileName:                   true,
+		syncd.TempFilePrefix + "download-12": true,
+		"a.txt":                              false,
+		"syncd-notes.txt":                    false,
+	} {
+		event := fsnotify.Event{Name: filepath.Join("dir", name), Op: fsnotify.Create}
+		if got := ignoreEvent(event); got != want {
+			t.Errorf("ignoreEvent(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDownloadedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("remote"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	downloads := newDownloadedFiles()
+	if downloads.unchanged(path) {
+		t.Error("unchanged before the download was recorded, want false")
+	}
+
+	downloads.add(path)
+	if !downloads.unchanged(path) {
+		t.Error("unchanged right after the download = false, want true")
+	}
+
+	// A local edit is a real change, and stays one
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if downloads.unchanged(path) {
+		t.Error("unchanged after a local edit = true, want false")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if downloads.unchanged(path) {
+		t.Error("unchanged after removal = true, want false")
+	}
+}
@@ -0,0 +1,90 @@
+package syncd
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// LoadAWSConfig builds the AWS config for cfg's credentials, region and endpoint
+func LoadAWSConfig(cfg *SyncConfig) (aws.Config, error) {
+	// Load default config, overriding credentials with static keys or a profile when set.
+	// With neither, the default chain (env vars, shared config, instance roles) is used.
+	var options []func(*config.LoadOptions) error
+	if cfg.AWSAccessKey != "" {
+		staticCredProvider := credentials.NewStaticCredentialsProvider(
+			cfg.AWSAccessKey,
+			cfg.AWSSecretKey,
+			"",
+		)
+		options = append(options, config.WithCredentialsProvider(staticCredProvider))
+	}
+	if cfg.AWSProfile != "" {
+		options = append(options, config.WithSharedConfigProfile(cfg.AWSProfile))
+	}
+	if cfg.Region != "" {
+		options = append(options, config.WithRegion(cfg.Region))
+	}
+	// Point the SDK at an S3-compatible endpoint instead of AWS
+	if cfg.EndpointURL != "" {
+		options = append(options, config.WithBaseEndpoint(cfg.EndpointURL))
+	}
+	// Fail stalled connections instead of letting them hang forever
+	if cfg.HTTPTimeout > 0 {
+		options = append(options, config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(cfg.HTTPTimeout)))
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(context.TODO(), options...)
+	if err != nil {
+		return awsConfig, err
+	}
+
+	// Swap in credentials for the assumed role, refreshed before they expire. The
+	// STS client retries on its own settings, separate from max_retries.
+	if cfg.AssumeRoleARN != "" {
+		stsClient := sts.NewFromConfig(awsConfig, func(o *sts.Options) {
+			o.RetryMaxAttempts = cfg.STSMaxRetries + 1
+		})
+		provider := stscreds.NewAssumeRoleProvider(stsClient, cfg.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = cfg.RoleSessionName
+			if cfg.ExternalID != "" {
+				o.ExternalID = &cfg.ExternalID
+			}
+		})
+		awsConfig.Credentials = aws.NewCredentialsCache(&assumeRoleProvider{
+			provider: provider,
+			roleARN:  cfg.AssumeRoleARN,
+			timeout:  cfg.STSTimeout,
+		})
+	}
+	return awsConfig, nil
+}
+
+// assumeRoleProvider bounds each AssumeRole call, retries included, by sts_timeout
+// and reports its failures as STS errors
+type assumeRoleProvider struct {
+	provider *stscreds.AssumeRoleProvider
+	roleARN  string
+	timeout  time.Duration
+}
+
+func (p *assumeRoleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		slog.Error("Failed to assume role with STS; S3 requests can't be signed", "role_arn", p.roleARN, "err", err)
+		return creds, &stsError{roleARN: p.roleARN, err: err}
+	}
+	return creds, nil
+}
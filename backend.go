@@ -0,0 +1,673 @@
+package syncd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Backend is the object store a sync runs against. Keys are full object keys,
+// including the prefix. Put, Get and the other short methods carry the sync's own
+// bookkeeping: markers, manifests and run logs. Synced files go through PutFile,
+// CopyFile and GetFile, which keep the headers and metadata the sync compares against.
+type Backend interface {
+	// Put writes body to key, replacing any existing object
+	Put(ctx context.Context, key string, body []byte) error
+	// Head returns the object's info, or nil if it doesn't exist
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns every object whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Get opens the object's contents; the caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// DeleteBatch removes keys in as few requests as the store allows and returns
+	// how many it deleted. Keys that fail on their own are logged and left out of
+	// the count; an error means a whole request failed.
+	DeleteBatch(ctx context.Context, keys []string) (int, error)
+	// Copy writes key from sourceKey's contents, keeping its metadata and headers
+	Copy(ctx context.Context, sourceKey, key string) error
+	// AbortStaleUploads discards incomplete uploads under the config's prefix that
+	// were started more than olderThan ago
+	AbortStaleUploads(ctx context.Context, olderThan time.Duration) error
+
+	// PutFile uploads a synced file of size bytes to key. body may be read more
+	// than once; it is rewound before every attempt.
+	PutFile(ctx context.Context, key string, body io.ReadSeeker, size int64, headers FileHeaders) error
+	// CopyFile writes key from sourceKey's contents with new headers, without
+	// sending the contents again
+	CopyFile(ctx context.Context, sourceKey, key string, headers FileHeaders) error
+	// GetFile opens a synced file's contents along with its info. It isn't retried,
+	// since reads can fail too; callers retry the whole download.
+	GetFile(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+}
+
+// ObjectInfo describes a stored object. Listings only fill in the first four fields.
+type ObjectInfo struct {
+	Key             string
+	Size            int64
+	ETag            string // quoted, as S3 returns it
+	LastModified    time.Time
+	Metadata        map[string]string
+	ContentType     string
+	ContentEncoding string
+	CacheControl    string
+}
+
+// FileHeaders are what a synced file is stored with besides its contents
+type FileHeaders struct {
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	ContentType      string            `json:"content_type,omitempty"`
+	ContentEncoding  string            `json:"content_encoding,omitempty"`
+	CacheControl     string            `json:"cache_control,omitempty"`
+	RedirectLocation string            `json:"redirect_location,omitempty"` // website redirect
+	MD5              string            `json:"-"`                           // hex MD5 of the contents, when the store should verify it
+}
+
+// s3Backend is the Backend for an S3 bucket. Calls are retried and bounded by
+// operation_timeout like every other S3 request.
+type s3Backend struct {
+	client S3API
+	cfg    *SyncConfig
+}
+
+// NewS3Backend returns a Backend for cfg's bucket. Put and Get only handle bookkeeping
+// objects, so they use marker_storage_class and the configured SSE but never SSE-C.
+// Synced files get every upload setting in cfg, including SSE-C and tags.
+func NewS3Backend(client S3API, cfg *SyncConfig) Backend {
+	return &s3Backend{client: client, cfg: cfg}
+}
+
+func (b *s3Backend) Put(ctx context.Context, key string, body []byte) error {
+	cfg := b.cfg
+	return withRetry(ctx, cfg.MaxRetries, "PUT of "+key, func() error {
+		opCtx, cancel := operationContext(ctx, cfg)
+		defer cancel()
+		_, err := b.client.PutObject(opCtx, &s3.PutObjectInput{
+			Bucket:               &cfg.BucketName,
+			Key:                  &key,
+			Body:                 bytes.NewReader(body),
+			StorageClass:         types.StorageClass(cfg.MarkerStorageClass),
+			ServerSideEncryption: types.ServerSideEncryption(cfg.SSE),
+			SSEKMSKeyId:          optionalString(cfg.SSEKMSKeyID),
+		})
+		return err
+	})
+}
+
+func (b *s3Backend) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	head, err := headS3Object(ctx, b.client, b.cfg, key)
+	if err != nil || head == nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:             key,
+		Size:            aws.ToInt64(head.ContentLength),
+		ETag:            aws.ToString(head.ETag),
+		LastModified:    aws.ToTime(head.LastModified),
+		Metadata:        head.Metadata,
+		ContentType:     aws.ToString(head.ContentType),
+		ContentEncoding: aws.ToString(head.ContentEncoding),
+		CacheControl:    aws.ToString(head.CacheControl),
+	}, nil
+}
+
+func (b *s3Backend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	cfg := b.cfg
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: &cfg.BucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		// A failed page leaves the paginator's token alone, so it is safe to retry
+		var output *s3.ListObjectsV2Output
+		err := withRetry(ctx, cfg.MaxRetries, "listing of "+prefix, func() error {
+			opCtx, cancel := operationContext(ctx, cfg)
+			defer cancel()
+			var err error
+			output, err = paginator.NextPage(opCtx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range output.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         aws.ToString(obj.ETag),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (b *s3Backend) Delete(ctx context.Context, key string) error {
+	cfg := b.cfg
+	return withRetry(ctx, cfg.MaxRetries, "DELETE of "+key, func() error {
+		opCtx, cancel := operationContext(ctx, cfg)
+		defer cancel()
+		_, err := b.client.DeleteObject(opCtx, &s3.DeleteObjectInput{
+			Bucket: &cfg.BucketName,
+			Key:    &key,
+		})
+		return err
+	})
+}
+
+func (b *s3Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	cfg := b.cfg
+	var content []byte
+	err := withRetry(ctx, cfg.MaxRetries, "GET of "+key, func() error {
+		opCtx, cancel := operationContext(ctx, cfg)
+		defer cancel()
+		output, err := b.client.GetObject(opCtx, &s3.GetObjectInput{
+			Bucket: &cfg.BucketName,
+			Key:    &key,
+		})
+		if err != nil {
+			return err
+		}
+		defer output.Body.Close()
+		// Read it all inside the retry so operation_timeout doesn't cut off the caller's reads
+		content, err = io.ReadAll(output.Body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (b *s3Backend) DeleteBatch(ctx context.Context, keys []string) (int, error) {
+	cfg := b.cfg
+	deleted := 0
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := min(start+maxDeleteBatch, len(keys))
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: &key})
+		}
+
+		var output *s3.DeleteObjectsOutput
+		err := withRetry(ctx, cfg.MaxRetries, "delete batch", func() error {
+			var err error
+			opCtx, cancel := operationContext(ctx, cfg)
+			defer cancel()
+			output, err = b.client.DeleteObjects(opCtx, &s3.DeleteObjectsInput{
+				Bucket: &cfg.BucketName,
+				Delete: &types.Delete{Objects: objects},
+			})
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+
+		// DeleteObjects succeeds as a whole even when individual keys fail
+		deleted += len(output.Deleted)
+		for _, deleteErr := range output.Errors {
+			slog.Error("Error deleting object", "key", aws.ToString(deleteErr.Key),
+				"code", aws.ToString(deleteErr.Code), "err", aws.ToString(deleteErr.Message))
+		}
+	}
+	return deleted, nil
+}
+
+// Copy copies server-side with the object's own metadata, encrypted like uploads
+func (b *s3Backend) Copy(ctx context.Context, sourceKey, key string) error {
+	cfg := b.cfg
+	copySource := (&url.URL{Path: cfg.BucketName + "/" + sourceKey}).EscapedPath()
+	return withRetry(ctx, cfg.MaxRetries, "copy of "+sourceKey, func() error {
+		opCtx, cancel := operationContext(ctx, cfg)
+		defer cancel()
+		_, err := b.client.CopyObject(opCtx, &s3.CopyObjectInput{
+			Bucket:                         &cfg.BucketName,
+			Key:                            &key,
+			CopySource:                     &copySource,
+			ServerSideEncryption:           types.ServerSideEncryption(cfg.SSE),
+			SSEKMSKeyId:                    optionalString(cfg.SSEKMSKeyID),
+			SSECustomerAlgorithm:           optionalString(cfg.SSECustomerAlgorithm),
+			SSECustomerKey:                 optionalString(cfg.SSECustomerKey),
+			SSECustomerKeyMD5:              optionalString(cfg.SSECustomerKeyMD5),
+			CopySourceSSECustomerAlgorithm: optionalString(cfg.SSECustomerAlgorithm),
+			CopySourceSSECustomerKey:       optionalString(cfg.SSECustomerKey),
+			CopySourceSSECustomerKeyMD5:    optionalString(cfg.SSECustomerKeyMD5),
+		})
+		return err
+	})
+}
+
+func (b *s3Backend) AbortStaleUploads(ctx context.Context, olderThan time.Duration) error {
+	return abortStaleMultiparts(ctx, b.client, b.cfg, olderThan)
+}
+
+// fileInput is the PutObject request for a synced file, without its body
+func (b *s3Backend) fileInput(key string, headers FileHeaders) *s3.PutObjectInput {
+	cfg := b.cfg
+	return &s3.PutObjectInput{
+		Bucket:                  &cfg.BucketName,
+		Key:                     &key,
+		Metadata:                headers.Metadata,
+		ContentType:             optionalString(headers.ContentType),
+		ContentEncoding:         optionalString(headers.ContentEncoding),
+		ContentLanguage:         optionalString(cfg.ContentLanguage),
+		CacheControl:            optionalString(headers.CacheControl),
+		Expires:                 expiresFor(cfg),
+		Tagging:                 optionalString(cfg.Tags.Encode()),
+		WebsiteRedirectLocation: optionalString(headers.RedirectLocation),
+		StorageClass:            types.StorageClass(cfg.StorageClass),
+		ServerSideEncryption:    types.ServerSideEncryption(cfg.SSE),
+		SSEKMSKeyId:             optionalString(cfg.SSEKMSKeyID),
+		SSECustomerAlgorithm:    optionalString(cfg.SSECustomerAlgorithm),
+		SSECustomerKey:          optionalString(cfg.SSECustomerKey),
+		SSECustomerKeyMD5:       optionalString(cfg.SSECustomerKeyMD5),
+	}
+}
+
+func (b *s3Backend) PutFile(ctx context.Context, key string, body io.ReadSeeker, size int64, headers FileHeaders) error {
+	cfg := b.cfg
+	input := b.fileInput(key, headers)
+	input.Body = body
+	// Have S3 verify the content it receives
+	if err := setUploadChecksum(cfg, input, headers.MD5); err != nil {
+		return err
+	}
+
+	// The uploader retries each request itself, so a failed part doesn't restart
+	// the whole upload
+	if size >= cfg.MultipartThreshold {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		err := uploadMultipart(ctx, b.client, cfg, input)
+		observeRequest(ctx, err)
+		return err
+	}
+
+	// Detach from shutdown cancellation so a single PUT that has started finishes
+	uploadCtx := context.WithoutCancel(ctx)
+	return withRetry(ctx, cfg.MaxRetries, "upload of "+key, func() error {
+		// Rewind in case a failed attempt consumed part of the body
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		opCtx, cancel := operationContext(uploadCtx, cfg)
+		defer cancel()
+		_, err := b.client.PutObject(opCtx, input)
+		return err
+	})
+}
+
+// CopyFile copies server-side. Metadata, tags and headers are taken from headers
+// and cfg, not the source.
+func (b *s3Backend) CopyFile(ctx context.Context, sourceKey, key string, headers FileHeaders) error {
+	cfg := b.cfg
+	input := b.fileInput(key, headers)
+	copySource := (&url.URL{Path: cfg.BucketName + "/" + sourceKey}).EscapedPath()
+	return withRetry(ctx, cfg.MaxRetries, "copy of "+sourceKey, func() error {
+		opCtx, cancel := operationContext(ctx, cfg)
+		defer cancel()
+		_, err := b.client.CopyObject(opCtx, &s3.CopyObjectInput{
+			Bucket:                         input.Bucket,
+			Key:                            input.Key,
+			CopySource:                     &copySource,
+			MetadataDirective:              types.MetadataDirectiveReplace,
+			Metadata:                       input.Metadata,
+			ContentType:                    input.ContentType,
+			ContentLanguage:                input.ContentLanguage,
+			ContentEncoding:                input.ContentEncoding,
+			CacheControl:                   input.CacheControl,
+			Expires:                        input.Expires,
+			TaggingDirective:               types.TaggingDirectiveReplace,
+			Tagging:                        input.Tagging,
+			WebsiteRedirectLocation:        input.WebsiteRedirectLocation,
+			StorageClass:                   input.StorageClass,
+			ServerSideEncryption:           input.ServerSideEncryption,
+			SSEKMSKeyId:                    input.SSEKMSKeyId,
+			SSECustomerAlgorithm:           input.SSECustomerAlgorithm,
+			SSECustomerKey:                 input.SSECustomerKey,
+			SSECustomerKeyMD5:              input.SSECustomerKeyMD5,
+			CopySourceSSECustomerAlgorithm: input.SSECustomerAlgorithm,
+			CopySourceSSECustomerKey:       input.SSECustomerKey,
+			CopySourceSSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+		})
+		return err
+	})
+}
+
+// GetFile's operation_timeout covers reading the body, up to Close
+func (b *s3Backend) GetFile(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	cfg := b.cfg
+	opCtx, cancel := operationContext(ctx, cfg)
+	output, err := b.client.GetObject(opCtx, &s3.GetObjectInput{
+		Bucket:               &cfg.BucketName,
+		Key:                  &key,
+		SSECustomerAlgorithm: optionalString(cfg.SSECustomerAlgorithm),
+		SSECustomerKey:       optionalString(cfg.SSECustomerKey),
+		SSECustomerKeyMD5:    optionalString(cfg.SSECustomerKeyMD5),
+	})
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	info := &ObjectInfo{
+		Key:             key,
+		Size:            aws.ToInt64(output.ContentLength),
+		ETag:            aws.ToString(output.ETag),
+		LastModified:    aws.ToTime(output.LastModified),
+		Metadata:        output.Metadata,
+		ContentEncoding: aws.ToString(output.ContentEncoding),
+	}
+	return &cancelingBody{ReadCloser: output.Body, cancel: cancel}, info, nil
+}
+
+// cancelingBody releases a request's context once its body is closed
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// localHeadersDir holds a localBackend's FileHeaders, as <key>.json, apart from the objects
+const localHeadersDir = ".syncd-headers"
+
+// localBackend stores objects as files under a root directory, one file per key,
+// with the headers of synced files in localHeadersDir. It's meant for tests and
+// local dry runs, not for production.
+type localBackend struct {
+	root string
+}
+
+// NewLocalBackend returns a Backend that keeps objects as files under root
+func NewLocalBackend(root string) Backend {
+	return &localBackend{root: root}
+}
+
+// path maps a key to its file, refusing keys that would land outside root or
+// among the stored headers
+func (b *localBackend) path(key string) (string, error) {
+	name := filepath.FromSlash(key)
+	if !filepath.IsLocal(name) || strings.SplitN(key, "/", 2)[0] == localHeadersDir {
+		return "", fmt.Errorf("key %q doesn't map to a file under %s", key, b.root)
+	}
+	return filepath.Join(b.root, name), nil
+}
+
+// headersPath is where key's FileHeaders are stored; call it after path accepted key
+func (b *localBackend) headersPath(key string) string {
+	return filepath.Join(b.root, localHeadersDir, filepath.FromSlash(key)+".json")
+}
+
+func (b *localBackend) Put(ctx context.Context, key string, body []byte) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := b.write(path, bytes.NewReader(body)); err != nil {
+		return err
+	}
+	return removeIfExists(b.headersPath(key))
+}
+
+func (b *localBackend) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	object, err := b.objectInfo(key, path, info)
+	if err != nil {
+		return nil, err
+	}
+	return object, b.loadHeaders(object)
+}
+
+func (b *localBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(b.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == b.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if path == filepath.Join(b.root, localHeadersDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		object, err := b.objectInfo(key, path, info)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *object)
+		return nil
+	})
+	return objects, err
+}
+
+func (b *localBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := removeIfExists(path); err != nil {
+		return err
+	}
+	return removeIfExists(b.headersPath(key))
+}
+
+func (b *localBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// DeleteBatch deletes keys one at a time; a file that can't be removed is logged
+// and skipped like a key S3 fails to delete
+func (b *localBackend) DeleteBatch(ctx context.Context, keys []string) (int, error) {
+	deleted := 0
+	for _, key := range keys {
+		if err := b.Delete(ctx, key); err != nil {
+			slog.Error("Error deleting object", "key", key, "err", err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (b *localBackend) Copy(ctx context.Context, sourceKey, key string) error {
+	object, err := b.Head(ctx, sourceKey)
+	if err != nil {
+		return err
+	}
+	if object == nil {
+		return fmt.Errorf("no object at %s: %w", sourceKey, os.ErrNotExist)
+	}
+	return b.CopyFile(ctx, sourceKey, key, FileHeaders{
+		Metadata:        object.Metadata,
+		ContentType:     object.ContentType,
+		ContentEncoding: object.ContentEncoding,
+		CacheControl:    object.CacheControl,
+	})
+}
+
+// AbortStaleUploads has nothing to do, since files are written in one piece
+func (b *localBackend) AbortStaleUploads(ctx context.Context, olderThan time.Duration) error {
+	return nil
+}
+
+func (b *localBackend) PutFile(ctx context.Context, key string, body io.ReadSeeker, size int64, headers FileHeaders) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := b.write(path, body); err != nil {
+		return err
+	}
+	if headers.MD5 != "" {
+		sum, err := fileMD5(path)
+		if err != nil {
+			return err
+		}
+		if sum != headers.MD5 {
+			return fmt.Errorf("content of %s doesn't match its MD5", key)
+		}
+	}
+	return b.storeHeaders(key, headers)
+}
+
+func (b *localBackend) CopyFile(ctx context.Context, sourceKey, key string, headers FileHeaders) error {
+	sourcePath, err := b.path(sourceKey)
+	if err != nil {
+		return err
+	}
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err := b.write(path, source); err != nil {
+		return err
+	}
+	return b.storeHeaders(key, headers)
+}
+
+func (b *localBackend) GetFile(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	object, err := b.Head(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if object == nil {
+		return nil, nil, fmt.Errorf("no object at %s: %w", key, os.ErrNotExist)
+	}
+	file, err := b.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, object, nil
+}
+
+// storeHeaders saves headers for key, leaving out MD5, which only checks the upload
+func (b *localBackend) storeHeaders(key string, headers FileHeaders) error {
+	content, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	return b.write(b.headersPath(key), bytes.NewReader(content))
+}
+
+// loadHeaders fills in object's metadata and headers from what was stored with it, if anything
+func (b *localBackend) loadHeaders(object *ObjectInfo) error {
+	content, err := os.ReadFile(b.headersPath(object.Key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var headers FileHeaders
+	if err := json.Unmarshal(content, &headers); err != nil {
+		return fmt.Errorf("error reading headers of %s: %w", object.Key, err)
+	}
+	object.Metadata = headers.Metadata
+	object.ContentType = headers.ContentType
+	object.ContentEncoding = headers.ContentEncoding
+	object.CacheControl = headers.CacheControl
+	return nil
+}
+
+// objectInfo describes the file at path, with an S3-style single-part ETag
+func (b *localBackend) objectInfo(key, path string, info os.FileInfo) (*ObjectInfo, error) {
+	sum, err := fileMD5(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ETag:         "\"" + sum + "\"",
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+// write stores body at path through a temporary file in the same directory, so
+// readers never see a partial object
+func (b *localBackend) write(path string, body io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), TempFilePrefix+"put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, body); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeIfExists removes path, treating a missing file as removed
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package syncd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLocalBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := NewLocalBackend(t.TempDir())
+
+	if err := backend.Put(ctx, "data/sub/syncd.txt", []byte("marker")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := backend.PutFile(ctx, "data/a.txt", bytes.NewReader([]byte("hello")), 5, FileHeaders{
+		Metadata:        map[string]string{mtimeMetadataKey: "2024-01-02T03:04:05Z"},
+		ContentType:     "text/plain",
+		ContentEncoding: contentEncodingGzip,
+		CacheControl:    "no-cache",
+		MD5:             "5d41402abc4b2a76b9719d911017c592",
+	}); err != nil {
+		t.Fatalf("PutFile: %v", err)
+	}
+	if err := backend.CopyFile(ctx, "data/a.txt", "data/b.txt", FileHeaders{ContentType: "text/html"}); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+
+	objects, err := backend.List(ctx, "data/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	if want := []string{"data/a.txt", "data/b.txt", "data/sub/syncd.txt"}; !slices.Equal(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+	if objects[0].Size != 5 || objects[0].ETag != "\"5d41402abc4b2a76b9719d911017c592\"" {
+		t.Errorf("listed %+v, want size 5 and the content MD5 as ETag", objects[0])
+	}
+
+	head, err := backend.Head(ctx, "data/a.txt")
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+	if head.Metadata[mtimeMetadataKey] != "2024-01-02T03:04:05Z" || head.ContentType != "text/plain" ||
+		head.ContentEncoding != contentEncodingGzip || head.CacheControl != "no-cache" {
+		t.Errorf("Head = %+v, want the headers from PutFile", head)
+	}
+	copied, err := backend.Head(ctx, "data/b.txt")
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+	if copied.ContentType != "text/html" || copied.Metadata != nil {
+		t.Errorf("Head of copy = %+v, want only the headers from CopyFile", copied)
+	}
+
+	body, info, err := backend.GetFile(ctx, "data/b.txt")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	content, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(content) != "hello" || info.ContentType != "text/html" {
+		t.Errorf("GetFile = %q, %+v, %v; want the copied content and headers", content, info, err)
+	}
+
+	// Put replaces an object's headers along with its contents
+	if err := backend.Put(ctx, "data/a.txt", []byte("plain")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if head, err := backend.Head(ctx, "data/a.txt"); err != nil || head.Metadata != nil || head.ContentType != "" {
+		t.Errorf("Head after Put = %+v, %v; want no headers", head, err)
+	}
+
+	for _, key := range []string{"data/a.txt", "data/missing.txt"} {
+		if err := backend.Delete(ctx, key); err != nil {
+			t.Errorf("Delete(%s): %v", key, err)
+		}
+	}
+	if head, err := backend.Head(ctx, "data/a.txt"); head != nil || err != nil {
+		t.Errorf("Head after Delete = %+v, %v; want nil, nil", head, err)
+	}
+	if _, _, err := backend.GetFile(ctx, "data/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetFile after Delete: %v, want a not-exist error", err)
+	}
+}
+
+func TestLocalBackendRejectsKeys(t *testing.T) {
+	ctx := context.Background()
+	backend := NewLocalBackend(t.TempDir())
+
+	for _, key := range []string{"../escape.txt", "/abs.txt", localHeadersDir + "/a.txt.json"} {
+		if err := backend.Put(ctx, key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded, want an error", key)
+		}
+	}
+	if err := backend.PutFile(ctx, "a.txt", bytes.NewReader([]byte("hello")), 5, FileHeaders{MD5: "00"}); err == nil {
+		t.Error("PutFile with a wrong MD5 succeeded, want an error")
+	}
+}
+
+func TestLocalBackendListMissingRoot(t *testing.T) {
+	backend := NewLocalBackend(filepath.Join(t.TempDir(), "missing"))
+	objects, err := backend.List(context.Background(), "")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("List = %v, %v; want no objects", objects, err)
+	}
+}
+
+func TestSyncerWithLocalBackend(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"sub/a.txt": "hello", "sub/b.json": `{"b": 1}`})
+	client := newFakeS3()
+	cfg := testConfig(t, dir, map[string]string{"compare": "checksum", "gzip_extensions": ".json"})
+
+	syncer := NewSyncer(client, cfg)
+	syncer.SetBackend(NewLocalBackend(t.TempDir()))
+	for run, wantUploaded := range []int{2, 0} {
+		result, err := syncer.Sync(ctx)
+		if err != nil {
+			t.Fatalf("sync %d: %v", run+1, err)
+		}
+		if result.FilesUploaded != wantUploaded {
+			t.Errorf("sync %d uploaded %d files, want %d", run+1, result.FilesUploaded, wantUploaded)
+		}
+	}
+	if keys := client.keys(); len(keys) != 0 {
+		t.Errorf("S3 client got objects %v, want none", keys)
+	}
+
+	// Pull restores the original content of gzipped files
+	restoreDir := t.TempDir()
+	pullCfg := *cfg
+	pullCfg.LocalDir = restoreDir
+	syncer.SetConfig(&pullCfg)
+	if err := syncer.Pull(ctx); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	for relPath, want := range map[string]string{"sub/a.txt": "hello", "sub/b.json": `{"b": 1}`} {
+		content, err := os.ReadFile(filepath.Join(restoreDir, filepath.FromSlash(relPath)))
+		if err != nil || string(content) != want {
+			t.Errorf("pulled %s = %q, %v; want %q", relPath, content, err, want)
+		}
+	}
+}
+
+func TestSyncerWithLocalBackendDeletes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"keep.txt": "keep"})
+	cfg := testConfig(t, dir, map[string]string{
+		"delete_removed":         "true",
+		"trash_prefix":           "trash",
+		"log_to_s3_prefix":       "logs",
+		"abort_stale_multiparts": "1h",
+	})
+	backend := NewLocalBackend(t.TempDir())
+	if err := backend.Put(ctx, "data/gone.txt", []byte("gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	// No S3 client at all: everything must go through the backend
+	syncer := NewSyncer(nil, cfg)
+	syncer.SetBackend(backend)
+	if err := syncer.AbortStaleMultiparts(ctx); err != nil {
+		t.Fatalf("AbortStaleMultiparts: %v", err)
+	}
+	result, err := syncer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.FilesDeleted != 1 {
+		t.Errorf("deleted %d objects, want 1", result.FilesDeleted)
+	}
+
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data, trash, logs []string
+	for _, obj := range objects {
+		switch dir, _, _ := strings.Cut(obj.Key, "/"); dir {
+		case "data":
+			data = append(data, obj.Key)
+		case "trash":
+			trash = append(trash, path.Base(obj.Key))
+		case "logs":
+			logs = append(logs, path.Ext(obj.Key))
+		}
+	}
+	if want := []string{"data/keep.txt"}; !slices.Equal(data, want) {
+		t.Errorf("objects under the prefix = %v, want %v", data, want)
+	}
+	if want := []string{"gone.txt"}; !slices.Equal(trash, want) {
+		t.Errorf("trashed objects = %v, want %v", trash, want)
+	}
+	if want := []string{".json", ".log"}; !slices.Equal(logs, want) {
+		t.Errorf("run log files = %v, want %v", logs, want)
+	}
+}
+
+func TestS3BackendListMergesPages(t *testing.T) {
+	client := newFakeS3()
+	client.pageSize = 2
+	for _, key := range []string{"data/a.txt", "data/b.txt", "data/c.txt", "data/sub/d.txt", "data/sub/e.txt", "other/f.txt"} {
+		client.put(key, []byte(key), nil)
+	}
+	cfg := testConfig(t, t.TempDir(), nil)
+
+	files, err := listS3Files(context.Background(), NewS3Backend(client, cfg), cfg.Prefix, cfg.SyncMarkerFile)
+	if err != nil {
+		t.Fatalf("listS3Files: %v", err)
+	}
+	got := slices.Sorted(maps.Keys(files))
+	if want := []string{"a.txt", "b.txt", "c.txt", "sub/d.txt", "sub/e.txt"}; !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+	if client.lists != 3 {
+		t.Errorf("listing took %d pages, want 3", client.lists)
+	}
+}
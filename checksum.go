@@ -0,0 +1,184 @@
+package syncd
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// sha256MetadataKey is the user metadata entry (x-amz-meta-sha256) holding the hex SHA-256 of the content
+const sha256MetadataKey = "sha256"
+
+// checksumIndex holds precomputed SHA-256 sums read from a sha256sum-style file
+type checksumIndex struct {
+	sums    map[string]string // relative path -> hex SHA-256
+	modTime time.Time         // when the index was written
+}
+
+// loadChecksumIndex reads lines of the form "<hex sha256>  <relative path>",
+// as written by sha256sum. Paths are relative to local_dir.
+func loadChecksumIndex(indexPath string) (*checksumIndex, error) {
+	file, err := os.Open(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checksum index: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error reading checksum index: %v", err)
+	}
+
+	index := &checksumIndex{
+		sums:    make(map[string]string),
+		modTime: info.ModTime(),
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sum, relPath, found := strings.Cut(line, " ")
+		if !found || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid checksum index line: %s", line)
+		}
+		// sha256sum marks binary mode with a leading '*'
+		relPath = strings.TrimPrefix(strings.TrimLeft(relPath, " "), "*")
+		relPath = strings.TrimPrefix(strings.ReplaceAll(relPath, "\\", "/"), "./")
+		index.sums[relPath] = strings.ToLower(sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading checksum index: %v", err)
+	}
+
+	return index, nil
+}
+
+// lookup returns the indexed checksum for a file, unless the file is missing
+// from the index or was modified after the index was written
+func (idx *checksumIndex) lookup(relPath string, info os.FileInfo) (string, bool) {
+	if idx == nil || info.ModTime().After(idx.modTime) {
+		return "", false
+	}
+	sum, exists := idx.sums[relPath]
+	return sum, exists
+}
+
+// fileSHA256 hashes a file's contents
+func fileSHA256(path string) (string, error) {
+	return hashFile(path, sha256.New())
+}
+
+// fileMD5 hashes a file's contents the way S3 computes single-part ETags
+func fileMD5(path string) (string, error) {
+	return hashFile(path, md5.New())
+}
+
+// hashFile streams a file through hash and returns the hex digest
+func hashFile(path string, hash hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// localMD5 returns the MD5 of the content that would be uploaded for f
+func localMD5(f *localFile) (string, error) {
+	if f.content != nil {
+		sum := md5.Sum(f.content)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return fileMD5(f.path)
+}
+
+// Upload checksums S3 can verify on receipt. MD5 is sent as Content-MD5; the
+// others are computed by the SDK and sent as x-amz-checksum-* headers.
+const checksumMD5 = "md5"
+
+var uploadChecksums = map[string]types.ChecksumAlgorithm{
+	"sha256": types.ChecksumAlgorithmSha256,
+	"sha1":   types.ChecksumAlgorithmSha1,
+	"crc32":  types.ChecksumAlgorithmCrc32,
+	"crc32c": types.ChecksumAlgorithmCrc32c,
+}
+
+// uploadMD5 returns the MD5 the store should check f's upload against, or ""
+// unless checksum=md5. Content-MD5 only covers single-request uploads, so
+// multipart uploads go unchecked with md5.
+func uploadMD5(cfg *SyncConfig, f *localFile) (string, error) {
+	if cfg.Checksum != checksumMD5 || f.size() >= cfg.MultipartThreshold {
+		return "", nil
+	}
+	return localMD5(f)
+}
+
+// setUploadChecksum asks S3 to reject an upload whose content doesn't match
+// cfg.Checksum, given the hex MD5 from uploadMD5
+func setUploadChecksum(cfg *SyncConfig, input *s3.PutObjectInput, md5Sum string) error {
+	switch cfg.Checksum {
+	case "":
+		return nil
+	case checksumMD5:
+		if md5Sum == "" {
+			return nil
+		}
+		raw, err := hex.DecodeString(md5Sum)
+		if err != nil {
+			return err
+		}
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(raw))
+	default:
+		input.ChecksumAlgorithm = uploadChecksums[cfg.Checksum]
+	}
+	return nil
+}
+
+// isMultipartETag reports whether an ETag is a multipart composite ("<md5 of md5s>-<parts>")
+// rather than the MD5 of the object's content
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
+
+// localSHA256 returns a file's SHA-256, preferring the checksum index and caching the result on f.
+// Normalized text is hashed in memory since the index describes the raw file.
+func localSHA256(index *checksumIndex, f *localFile) (string, error) {
+	if f.sha256 != "" {
+		return f.sha256, nil
+	}
+	if f.content != nil {
+		sum := sha256.Sum256(f.content)
+		f.sha256 = hex.EncodeToString(sum[:])
+		return f.sha256, nil
+	}
+	if sum, exists := index.lookup(f.relPath, f.info); exists {
+		f.sha256 = sum
+		return sum, nil
+	}
+
+	sum, err := fileSHA256(f.path)
+	if err != nil {
+		return "", err
+	}
+	f.sha256 = sum
+	return sum, nil
+}
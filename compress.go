@@ -0,0 +1,44 @@
+package syncd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+)
+
+// contentEncodingGzip is the Content-Encoding of objects uploaded for gzip_extensions
+const contentEncodingGzip = "gzip"
+
+// User metadata entries of gzipped objects holding the size and hex MD5 of the
+// content before compression, which is what a download writes
+const (
+	decodedSizeMetadataKey = "uncompressed-size"
+	decodedMD5MetadataKey  = "uncompressed-md5"
+)
+
+// decodedSize returns the size a download of object writes: the recorded uncompressed
+// size for gzip-encoded objects, which is unknown when it wasn't recorded
+func decodedSize(object *ObjectInfo) (int64, bool) {
+	if object.ContentEncoding != contentEncodingGzip {
+		return object.Size, true
+	}
+	size, err := strconv.ParseInt(object.Metadata[decodedSizeMetadataKey], 10, 64)
+	return size, err == nil
+}
+
+// gzipContent compresses content. The gzip header carries no name or mtime, so the
+// same input always compresses to the same bytes and ETag.
+func gzipContent(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
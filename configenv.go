@@ -0,0 +1,43 @@
+package syncd
+
+import "os"
+
+// envOverrides maps environment variables to the config keys they override.
+// Values set in the environment win over the config file, so secrets can be
+// injected into containers instead of being written to disk.
+var envOverrides = map[string]string{
+	"SYNCD_AWS_ACCESS_KEY":       "aws_access_key",
+	"SYNCD_AWS_SECRET_KEY":       "aws_secret_key",
+	"SYNCD_AWS_PROFILE":          "aws_profile",
+	"SYNCD_ASSUME_ROLE_ARN":      "assume_role_arn",
+	"SYNCD_EXTERNAL_ID":          "external_id",
+	"SYNCD_REGION":               "region",
+	"SYNCD_ENDPOINT_URL":         "endpoint_url",
+	"SYNCD_LOCAL_DIR":            "local_dir",
+	"SYNCD_BUCKET_NAME":          "bucket_name",
+	"SYNCD_PREFIX":               "prefix",
+	"SYNCD_SYNC_INTERVAL":        "sync_interval",
+	"SYNCD_SCHEDULE":             "schedule",
+	"SYNCD_SSE_KMS_KEY_ID":       "sse_kms_key_id",
+	"SYNCD_SSE_CUSTOMER_KEY":     "sse_customer_key",
+	"SYNCD_LOG_LEVEL":            "log_level",
+	"SYNCD_LOG_FORMAT":           "log_format",
+	"SYNCD_METRICS_ADDR":         "metrics_addr",
+	"SYNCD_HEALTH_ADDR":          "health_addr",
+	"SYNCD_DRY_RUN":              "dry_run",
+	"SYNCD_MAX_BANDWIDTH":        "max_bandwidth",
+	"SYNCD_LOG_TO_S3_PREFIX":     "log_to_s3_prefix",
+	"SYNCD_OPERATION_TIMEOUT":    "operation_timeout",
+	"SYNCD_HTTP_TIMEOUT":         "http_timeout",
+	"SYNCD_MARKER_STORAGE_CLASS": "marker_storage_class",
+	"SYNCD_STORAGE_CLASS":        "storage_class",
+}
+
+// applyEnvOverrides replaces config keys with any values set in the environment
+func applyEnvOverrides(configMap map[string]string) {
+	for env, key := range envOverrides {
+		if value, set := os.LookupEnv(env); set {
+			configMap[key] = value
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package syncd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isYAMLConfig reports whether a config path should be parsed as YAML
+func isYAMLConfig(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// readYAMLConfigSections reads a YAML config into the same shared keys and target
+// sections as the key=value format, so both are validated by parseConfig. Keys are
+// the same as in the flat format; lists become comma-separated values, tags may be
+// a mapping, content_type and website_redirect are mappings, and targets is a list
+// of mappings with an optional name.
+func readYAMLConfigSections(path string) (map[string]string, []configSection, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening config file: %v", err)
+	}
+	var document map[string]any
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	rawTargets, hasTargets := document["targets"]
+	delete(document, "targets")
+	shared, err := flattenYAML(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hasTargets {
+		return shared, nil, nil
+	}
+
+	targetList, ok := rawTargets.([]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid targets: expected a list of mappings")
+	}
+	var sections []configSection
+	names := make(map[string]bool)
+	for i, rawTarget := range targetList {
+		target, ok := rawTarget.(map[string]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid target %d: expected a mapping", i+1)
+		}
+		values, err := flattenYAML(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("target %d: %w", i+1, err)
+		}
+		name := values["name"]
+		delete(values, "name")
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		if names[name] {
+			return nil, nil, fmt.Errorf("duplicate target: %s", name)
+		}
+		names[name] = true
+		sections = append(sections, configSection{name: name, values: values})
+	}
+	return shared, sections, nil
+}
+
+// flattenYAML converts one YAML mapping into flat config keys
+func flattenYAML(document map[string]any) (map[string]string, error) {
+	configMap := make(map[string]string)
+	for key, value := range document {
+		switch value := value.(type) {
+		case nil:
+			configMap[key] = ""
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				scalar, err := yamlScalar(key, item)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, scalar)
+			}
+			configMap[key] = strings.Join(items, ",")
+		case map[string]any:
+			if key == "tags" {
+				tags := url.Values{}
+				for tagKey, tagValue := range value {
+					scalar, err := yamlScalar(key, tagValue)
+					if err != nil {
+						return nil, err
+					}
+					tags.Set(tagKey, scalar)
+				}
+				configMap[key] = tags.Encode()
+				continue
+			}
+			// content_type and website_redirect entries, keyed by extension or path
+			for subkey, subvalue := range value {
+				scalar, err := yamlScalar(key, subvalue)
+				if err != nil {
+					return nil, err
+				}
+				configMap[key+"."+subkey] = scalar
+			}
+		default:
+			scalar, err := yamlScalar(key, value)
+			if err != nil {
+				return nil, err
+			}
+			configMap[key] = scalar
+		}
+	}
+	return configMap, nil
+}
+
+// yamlScalar formats a YAML scalar the way it would be written in the flat format
+func yamlScalar(key string, value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case int, float64, bool:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("invalid %s: unsupported YAML value %v", key, value)
+	}
+}
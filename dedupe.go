@@ -0,0 +1,21 @@
+package syncd
+
+// maxCopySize is the largest object a single CopyObject call can copy
+const maxCopySize = 5 << 30
+
+// dedupeSource returns the key of a file with the same SHA-256 uploaded earlier this run
+func (s *syncState) dedupeSource(sum string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, exists := s.dedupeKeys[sum]
+	return key, exists
+}
+
+// recordUploadHash remembers key as a copy source for later files with the same SHA-256
+func (s *syncState) recordUploadHash(sum, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.dedupeKeys[sum]; !exists {
+		s.dedupeKeys[sum] = key
+	}
+}
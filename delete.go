@@ -0,0 +1,281 @@
+package syncd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxDeleteBatch is the most keys S3 accepts in a single DeleteObjects request
+const maxDeleteBatch = 1000
+
+// deleteObjects removes keys, copying them to trash_prefix first when it is set,
+// and returns how many were deleted. Keys that fail to be trashed or deleted are
+// logged individually and returned as one error.
+func deleteObjects(ctx context.Context, backend Backend, cfg *SyncConfig, keys []string) (int, error) {
+	total := len(keys)
+
+	failed := 0
+	if cfg.TrashPrefix != "" {
+		keys, failed = moveToTrash(ctx, backend, cfg, keys)
+	}
+	deleted, err := backend.DeleteBatch(ctx, keys)
+	if err != nil {
+		return deleted, fmt.Errorf("error deleting objects: %v", err)
+	}
+	failed += len(keys) - deleted
+
+	if failed > 0 {
+		return deleted, fmt.Errorf("failed to delete %d of %d objects", failed, total)
+	}
+	return deleted, nil
+}
+
+// moveToTrash copies keys to trash_prefix/<timestamp>/<key> so deletes can be undone,
+// and returns the keys that were copied and are safe to delete. Keys that fail to
+// copy are logged, counted and kept.
+func moveToTrash(ctx context.Context, backend Backend, cfg *SyncConfig, keys []string) ([]string, int) {
+	trashDir := objectKey(cfg.TrashPrefix, time.Now().UTC().Format("20060102T150405Z"))
+
+	copied := make([]string, 0, len(keys))
+	failed := 0
+	for _, key := range keys {
+		trashKey := objectKey(trashDir, key)
+		if err := backend.Copy(ctx, key, trashKey); err != nil {
+			failed++
+			slog.Error("Error moving object to trash, not deleting it", "key", key, "err", err)
+			continue
+		}
+		slog.Debug("Copied object to trash", "key", key, "trash_key", trashKey)
+		copied = append(copied, key)
+	}
+	return copied, failed
+}
+
+// isDeleteProtected reports whether a relative path falls under one of the
+// no_delete_prefixes safety prefixes
+func isDeleteProtected(cfg *SyncConfig, relPath string) bool {
+	for _, protected := range cfg.NoDeletePrefixes {
+		if strings.HasPrefix(relPath, protected) {
+			return true
+		}
+	}
+	return false
+}
+
+// isKept reports whether a key relative to the prefix must never be deleted: sync
+// markers, the _SUCCESS marker, sidecar files, keys matching keep and no_delete_prefixes paths
+func isKept(cfg *SyncConfig, relPath string) bool {
+	if path.Base(relPath) == cfg.SyncMarkerFile || relPath == successMarkerName || strings.HasSuffix(relPath, sidecarSuffix) {
+		return true
+	}
+	return matchAny(cfg.Keep, relPath) || isDeleteProtected(cfg, relPath)
+}
+
+// outsideSync reports whether a remote key, relative to the prefix, is never synced in
+// either direction: a kept key (see isKept), or a run log or trashed object
+func outsideSync(cfg *SyncConfig, relPath string) bool {
+	if isKept(cfg, relPath) {
+		return true
+	}
+	key := objectKey(cfg.Prefix, relPath)
+	if cfg.LogToS3Prefix != "" && strings.HasPrefix(key, dirPrefix(cfg.LogToS3Prefix)) {
+		return true
+	}
+	// Trashed objects stay until a lifecycle rule or an operator removes them
+	return cfg.TrashPrefix != "" && strings.HasPrefix(key, dirPrefix(cfg.TrashPrefix))
+}
+
+// dirPrefix returns a configured prefix as a directory: slash-separated, ending in one slash
+func dirPrefix(prefix string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(prefix, "\\", "/"), "/") + "/"
+}
+
+// checkMaxDelete enforces max_delete for a delete of count objects out of remoteTotal.
+// Exceeding it usually means LocalDir or the delete list is wrong, so it's fatal.
+func checkMaxDelete(cfg *SyncConfig, count, remoteTotal int) error {
+	limit := cfg.MaxDelete
+	if cfg.MaxDeletePercent >= 0 {
+		limit = int(float64(remoteTotal) * cfg.MaxDeletePercent / 100)
+	}
+	if limit < 0 || count <= limit {
+		return nil
+	}
+
+	slog.Error("REFUSING TO DELETE: delete exceeds max_delete. Check that local_dir is mounted and populated.",
+		"count", count, "remote_total", remoteTotal, "bucket", cfg.BucketName, "prefix", cfg.Prefix, "max_delete", limit)
+	return &configError{fmt.Errorf("delete of %d objects exceeds max_delete (%d)", count, limit)}
+}
+
+// remoteOnly returns the keys in the remote listing, relative to the prefix, that have
+// no local file. Keys outside the sync's scope (kept keys, paths the walk prunes or
+// filters out, local entries it skips, the run log and trash prefixes) are left out
+// so they can never be deleted.
+func remoteOnly(cfg *SyncConfig, state *syncState) ([]string, error) {
+	localFiles, err := listFiles(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %v", cfg.LocalDir, err)
+	}
+	localKeys := make(map[string]bool, len(localFiles))
+	for relPath := range localFiles {
+		localKeys[remoteRelPath(cfg, relPath)] = true
+	}
+
+	filter := newWalkFilter(cfg)
+	// Rewritten keys can't be mapped back to local paths, so protect the keys the
+	// out-of-scope local files would have been uploaded to instead
+	var protected map[string]bool
+	if cfg.KeyRewrite != nil {
+		if protected, err = outOfScopeKeys(cfg, filter); err != nil {
+			return nil, fmt.Errorf("error listing %s: %v", cfg.LocalDir, err)
+		}
+	}
+
+	var keys []string
+	for relPath := range state.remoteFiles {
+		if localKeys[relPath] || outsideSync(cfg, relPath) {
+			continue
+		}
+		if protected[relPath] || (cfg.KeyRewrite == nil && filter.outOfScope(relPath)) {
+			continue
+		}
+		keys = append(keys, relPath)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// outOfScopeKeys walks all of LocalDir, pruning nothing, and returns the rewritten
+// keys (relative to the prefix) of files that are out of the sync's scope
+func outOfScopeKeys(cfg *SyncConfig, filter *walkFilter) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	err := walkLocalDir(cfg, func(path, relPath string, info os.FileInfo, err error) error {
+		// Unreadable entries can't be mapped, and the upload walk reports them
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if filter.outOfScope(relPath) {
+			keys[remoteRelPath(cfg, relPath)] = true
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// deleteRemoved deletes objects whose local file no longer exists when delete_removed
+// is on, subject to max_delete. Otherwise it only reports how many there are.
+func deleteRemoved(ctx context.Context, cfg *SyncConfig, state *syncState) error {
+	relPaths, err := remoteOnly(cfg, state)
+	if err != nil {
+		return err
+	}
+	if len(relPaths) == 0 {
+		return nil
+	}
+	if !cfg.DeleteRemoved {
+		slog.Info("Found objects in S3 with no local file, leaving them untouched (delete_removed=false)", "count", len(relPaths))
+		return nil
+	}
+	if err := checkMaxDelete(cfg, len(relPaths), len(state.remoteFiles)); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(relPaths))
+	for _, relPath := range relPaths {
+		key := objectKey(cfg.Prefix, relPath)
+		if cfg.DryRun {
+			slog.Info("[dry-run] Would delete", "key", key)
+		} else {
+			slog.Debug("Deleting", "key", key)
+		}
+		keys = append(keys, key)
+	}
+	if cfg.DryRun {
+		state.deleted = len(keys)
+		slog.Info("[dry-run] Summary", "would_delete", len(keys))
+		return nil
+	}
+
+	state.deleted, err = deleteObjects(ctx, state.backend, cfg, keys)
+	slog.Info("Deleted objects", "count", state.deleted)
+	return err
+}
+
+// deleteFromFile deletes the newline-separated relative paths listed in listPath.
+// Paths are resolved under cfg.Prefix; missing or protected keys are skipped with a warning.
+func deleteFromFile(ctx context.Context, backend Backend, cfg *SyncConfig, listPath string) error {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return fmt.Errorf("error opening delete list: %v", err)
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue // Skip empty lines and comments
+		}
+
+		// Refuse paths that would resolve outside the configured prefix
+		relPath := path.Clean(strings.ReplaceAll(line, "\\", "/"))
+		if path.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			slog.Warn("Skipping path that escapes the configured prefix", "path", line)
+			continue
+		}
+		if isKept(cfg, relPath) {
+			slog.Warn("Skipping path protected by keep or no_delete_prefixes", "path", relPath)
+			continue
+		}
+
+		s3Key := objectKey(cfg.Prefix, remoteRelPath(cfg, relPath))
+		head, err := backend.Head(ctx, s3Key)
+		if err != nil {
+			return err
+		}
+		if head == nil {
+			slog.Warn("Skipping path that does not exist in S3", "path", relPath, "key", s3Key)
+			continue
+		}
+		keys = append(keys, s3Key)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading delete list: %v", err)
+	}
+
+	// A percentage limit needs to know how many objects are under the prefix
+	remoteTotal := 0
+	if cfg.MaxDeletePercent >= 0 {
+		remoteFiles, err := listS3Files(ctx, backend, cfg.Prefix, cfg.SyncMarkerFile)
+		if err != nil {
+			return fmt.Errorf("error listing s3://%s/%s: %v", cfg.BucketName, cfg.Prefix, err)
+		}
+		remoteTotal = len(remoteFiles)
+	}
+	if err := checkMaxDelete(cfg, len(keys), remoteTotal); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if cfg.DryRun {
+			slog.Info("[dry-run] Would delete", "key", key)
+		} else {
+			slog.Debug("Deleting", "key", key)
+		}
+	}
+	if cfg.DryRun {
+		slog.Info("[dry-run] Summary", "would_delete", len(keys))
+		return nil
+	}
+
+	deleted, err := deleteObjects(ctx, backend, cfg, keys)
+	slog.Info("Deleted objects", "count", deleted)
+	return err
+}
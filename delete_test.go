@@ -0,0 +1,80 @@
+package syncd
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestDeleteRemoved(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		remote   []string // extra keys with no local file
+		want     []string // keys left after the sync
+		wantErr  bool
+	}{
+		{
+			name:   "delete_removed off",
+			remote: []string{"data/gone.txt"},
+			want:   []string{"data/gone.txt", "data/keep.txt"},
+		},
+		{
+			name:     "deletes remote-only keys",
+			settings: map[string]string{"delete_removed": "true"},
+			remote:   []string{"data/gone.txt", "data/sub/gone.txt"},
+			want:     []string{"data/keep.txt"},
+		},
+		{
+			name:     "keeps excluded keys",
+			settings: map[string]string{"delete_removed": "true", "exclude": "*.log"},
+			remote:   []string{"data/app.log", "data/gone.txt"},
+			want:     []string{"data/app.log", "data/keep.txt"},
+		},
+		{
+			name:     "keeps keep globs",
+			settings: map[string]string{"delete_removed": "true", "keep": "archive/*"},
+			remote:   []string{"data/archive/old.txt", "data/gone.txt"},
+			want:     []string{"data/archive/old.txt", "data/keep.txt"},
+		},
+		{
+			name:     "keeps pruned directories",
+			settings: map[string]string{"delete_removed": "true", "max_depth": "1"},
+			remote:   []string{"data/a/b/deep.txt", "data/a/gone.txt"},
+			want:     []string{"data/a/b/deep.txt", "data/keep.txt"},
+		},
+		{
+			name:     "dry run deletes nothing",
+			settings: map[string]string{"delete_removed": "true", "dry_run": "true"},
+			remote:   []string{"data/gone.txt"},
+			want:     []string{"data/gone.txt"},
+		},
+		{
+			name:     "max_delete exceeded",
+			settings: map[string]string{"delete_removed": "true", "max_delete": "1"},
+			remote:   []string{"data/gone1.txt", "data/gone2.txt"},
+			want:     []string{"data/gone1.txt", "data/gone2.txt", "data/keep.txt"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"keep.txt": "keep"})
+			client := newFakeS3()
+			for _, key := range tt.remote {
+				client.put(key, []byte("remote"), nil)
+			}
+			cfg := testConfig(t, dir, tt.settings)
+
+			_, err := performFullSync(context.Background(), NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("performFullSync error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := client.keys(); !slices.Equal(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package syncd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// DiffReport lists how LocalDir and the bucket prefix differ
+type DiffReport struct {
+	Target  string   `json:"target,omitempty"`
+	Bucket  string   `json:"bucket"`
+	Prefix  string   `json:"prefix"`
+	Compare string   `json:"compare"`
+	Upload  []string `json:"upload"`  // local files missing from S3
+	Changed []string `json:"changed"` // local files whose object differs according to compare
+	Delete  []string `json:"delete"`  // keys under the prefix, relative to it, with no local file
+}
+
+// diffSync compares LocalDir against the bucket without modifying either
+func diffSync(ctx context.Context, backend Backend, cfg *SyncConfig, failedSubdirs *subdirSet) (*DiffReport, error) {
+	state, err := newSyncState(ctx, backend, cfg, failedSubdirs)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing diff: %w", err)
+	}
+	localFiles, err := listFiles(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %v", cfg.LocalDir, err)
+	}
+
+	report := &DiffReport{
+		Target:  cfg.Name,
+		Bucket:  cfg.BucketName,
+		Prefix:  cfg.Prefix,
+		Compare: cfg.Compare,
+		Upload:  []string{},
+		Changed: []string{},
+	}
+	for relPath, info := range localFiles {
+		if _, exists := state.remoteFiles[remoteRelPath(cfg, relPath)]; !exists {
+			report.Upload = append(report.Upload, relPath)
+			continue
+		}
+
+		// Present on both sides; decide like a sync would, using the compare mode
+		localPath := filepath.Join(cfg.LocalDir, filepath.FromSlash(relPath))
+		f := &localFile{path: localPath, relPath: relPath, info: info}
+		changed, err := needsUpload(ctx, cfg, state, objectKey(cfg.Prefix, remoteRelPath(cfg, relPath)), f)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			report.Changed = append(report.Changed, relPath)
+		}
+	}
+	sort.Strings(report.Upload)
+	sort.Strings(report.Changed)
+
+	if report.Delete, err = remoteOnly(cfg, state); err != nil {
+		return nil, err
+	}
+	if report.Delete == nil {
+		report.Delete = []string{}
+	}
+	return report, nil
+}
+
+// WriteText writes the report grouped by kind of difference
+func (r *DiffReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Diff for s3://%s/%s\n", r.Bucket, r.Prefix)
+	groups := []struct {
+		title string
+		paths []string
+	}{
+		{"Missing from S3 (would upload)", r.Upload},
+		{fmt.Sprintf("Changed (would re-upload, compare=%s)", r.Compare), r.Changed},
+		{"Only in S3 (deleted with delete_removed=true)", r.Delete},
+	}
+	for _, group := range groups {
+		fmt.Fprintf(w, "  %s: %d\n", group.title, len(group.paths))
+		for _, path := range group.paths {
+			fmt.Fprintf(w, "    %s\n", path)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package syncd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDiffFollowsSymlinks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+	target := filepath.Join(dir, "a.txt")
+	if err := os.Symlink(target, filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	client := newFakeS3()
+	cfg := testConfig(t, dir, map[string]string{"symlinks": "follow", "compare": "size"})
+
+	if _, err := performFullSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+		t.Fatalf("performFullSync: %v", err)
+	}
+	report, err := diffSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{})
+	if err != nil {
+		t.Fatalf("diffSync: %v", err)
+	}
+	if len(report.Upload) != 0 || len(report.Changed) != 0 || len(report.Delete) != 0 {
+		t.Errorf("diff after sync = %+v, want no differences", report)
+	}
+
+	if err := os.WriteFile(target, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = diffSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}); err != nil {
+		t.Fatalf("diffSync: %v", err)
+	}
+	if want := []string{"a.txt", "link.txt"}; !slices.Equal(report.Changed, want) {
+		t.Errorf("Changed = %v, want %v", report.Changed, want)
+	}
+}
@@ -0,0 +1,51 @@
+package syncd
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// secretConfigFields lists SyncConfig fields whose values must never be printed
+var secretConfigFields = map[string]bool{
+	"AWSAccessKey":      true,
+	"AWSSecretKey":      true,
+	"SSECustomerKey":    true,
+	"SSECustomerKeyMD5": true,
+}
+
+// DiffConfigFiles loads two config files and writes their field-level differences to w
+func DiffConfigFiles(w io.Writer, pathA, pathB string) error {
+	configA, err := ReadConfigFile(pathA)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %v", pathA, err)
+	}
+	configB, err := ReadConfigFile(pathB)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %v", pathB, err)
+	}
+
+	differences := 0
+	valueA := reflect.ValueOf(*configA)
+	valueB := reflect.ValueOf(*configB)
+	for i := 0; i < valueA.NumField(); i++ {
+		name := valueA.Type().Field(i).Name
+		fieldA := valueA.Field(i).Interface()
+		fieldB := valueB.Field(i).Interface()
+		if reflect.DeepEqual(fieldA, fieldB) {
+			continue
+		}
+
+		differences++
+		if secretConfigFields[name] {
+			fmt.Fprintf(w, "%s: [redacted] -> [redacted]\n", name)
+		} else {
+			fmt.Fprintf(w, "%s: %v -> %v\n", name, fieldA, fieldB)
+		}
+	}
+
+	if differences == 0 {
+		fmt.Fprintln(w, "No differences")
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package syncd
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sync directions
+const (
+	directionUp   = "up"   // local -> S3 only
+	directionDown = "down" // S3 -> local only
+	directionBoth = "both" // download, then upload
+)
+
+// Downloads reports whether syncs with cfg write into LocalDir (direction down or both)
+func (cfg *SyncConfig) Downloads() bool {
+	return cfg.Direction != directionUp
+}
+
+// Conflict policies for direction=both when a file differs on each side
+const (
+	conflictNewer  = "newer"  // the side with the later modification time wins
+	conflictLocal  = "local"  // never overwrite a differing local file
+	conflictRemote = "remote" // always overwrite a differing local file
+)
+
+// downloadFromS3 downloads objects from the remote listing in state that are missing
+// locally or should replace the local copy. Downloaded files get the source mtime
+// recorded at upload time, so a following upload pass sees them as in sync.
+func downloadFromS3(ctx context.Context, cfg *SyncConfig, state *syncState) error {
+	relPaths := make([]string, 0, len(state.remoteFiles))
+	for relPath := range state.remoteFiles {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	downloaded := 0
+	for _, relPath := range relPaths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Directory placeholders and keys that would land outside LocalDir are never written
+		if strings.HasSuffix(relPath, "/") || !filepath.IsLocal(filepath.FromSlash(relPath)) {
+			slog.Warn("Skipping key that doesn't map to a local file", "path", relPath)
+			continue
+		}
+		if !downloadable(cfg, relPath) {
+			continue
+		}
+
+		s3Key := objectKey(cfg.Prefix, relPath)
+		localPath := filepath.Join(cfg.LocalDir, filepath.FromSlash(relPath))
+
+		download, err := needsDownload(ctx, cfg, state, s3Key, localPath)
+		if err != nil {
+			return err
+		}
+		if !download {
+			continue
+		}
+
+		if cfg.DryRun {
+			slog.Info("[dry-run] Would download", "key", s3Key, "path", localPath)
+			downloaded++
+			continue
+		}
+		if err := downloadFile(ctx, state.backend, cfg, s3Key, localPath); err != nil {
+			slog.Error("Error downloading", "key", s3Key, "err", err)
+			if err := state.tolerate(ctx, cfg, localPath, err); err != nil {
+				return err
+			}
+			continue
+		}
+		slog.Debug("Downloaded file", "key", s3Key, "path", localPath)
+		if state.onDownload != nil {
+			state.onDownload(localPath)
+		}
+		downloaded++
+	}
+
+	slog.Info("Downloaded files", "count", downloaded)
+	return nil
+}
+
+// downloadable reports whether the object at relPath, relative to the prefix, is a
+// synced file: not a marker, run log, trashed or kept key, and not filtered out by
+// exclude or include
+func downloadable(cfg *SyncConfig, relPath string) bool {
+	if outsideSync(cfg, relPath) || matchAny(cfg.Exclude, relPath) {
+		return false
+	}
+	return len(cfg.Include) == 0 || matchAny(cfg.Include, relPath)
+}
+
+// needsDownload decides whether the object at s3Key should be written to localPath.
+// Missing local files are always downloaded; differing ones follow cfg.Conflict,
+// or "remote is newer" in direction=down.
+func needsDownload(ctx context.Context, cfg *SyncConfig, state *syncState, s3Key, localPath string) (bool, error) {
+	info, err := os.Lstat(localPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		slog.Warn("Not overwriting non-regular local file", "path", localPath)
+		return false, nil
+	}
+
+	// The listing has no user metadata, so fetch the source mtime recorded at upload
+	state.countHead()
+	head, err := state.backend.Head(ctx, s3Key)
+	if err != nil || head == nil {
+		return false, err
+	}
+	remoteMtime := sourceMtime(head.Metadata, head.LastModified)
+	localMtime := info.ModTime().Truncate(time.Second)
+
+	// A gzip-encoded object's size is the compressed size, so compare the recorded
+	// uncompressed size, or only the mtime when there is none
+	size, known := decodedSize(head)
+	sizeMatches := !known || size == info.Size()
+	diff := remoteMtime.Sub(localMtime)
+	if sizeMatches && diff <= cfg.MtimeTolerance && diff >= -cfg.MtimeTolerance {
+		return false, nil
+	}
+
+	switch {
+	case cfg.Direction == directionDown || cfg.Conflict == conflictNewer:
+		if diff > cfg.MtimeTolerance {
+			slog.Debug("Remote copy is newer, downloading", "path", localPath)
+			return true, nil
+		}
+		return false, nil
+	case cfg.Conflict == conflictRemote:
+		slog.Debug("Local copy differs, replacing it with the remote copy", "path", localPath)
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// uploadWins decides whether f replaces the existing object at s3Key in
+// direction=both. It compares f with the size and source mtime recorded at
+// upload, like needsDownload does, and lets cfg.Conflict settle a difference.
+// The download pass has already run, so a local copy that lost to the remote
+// one is in sync by now.
+func uploadWins(ctx context.Context, cfg *SyncConfig, state *syncState, s3Key string, f *localFile) (bool, error) {
+	state.countHead()
+	head, err := state.backend.Head(ctx, s3Key)
+	if err != nil {
+		return false, err
+	}
+	if head == nil {
+		return true, nil
+	}
+	remoteMtime := sourceMtime(head.Metadata, head.LastModified)
+	localMtime := f.info.ModTime().Truncate(time.Second)
+
+	// Gzipped objects record the size of the content before compression
+	localSize := f.size()
+	if f.gzipped {
+		localSize = f.decodedSize
+	}
+	size, known := decodedSize(head)
+	sizeMatches := !known || size == localSize
+	diff := localMtime.Sub(remoteMtime)
+	if sizeMatches && diff <= cfg.MtimeTolerance && diff >= -cfg.MtimeTolerance {
+		return headersChanged(ctx, cfg, state, s3Key, f, head)
+	}
+
+	switch cfg.Conflict {
+	case conflictLocal:
+		slog.Debug("Local copy differs, replacing the remote copy", "key", s3Key)
+		return true, nil
+	case conflictRemote:
+		return false, nil
+	default:
+		if diff > cfg.MtimeTolerance {
+			slog.Debug("Local copy is newer, uploading", "key", s3Key)
+			return true, nil
+		}
+		return false, nil
+	}
+}
+
+// sourceMtime returns the mtime recorded in upload metadata, or fallback when there is none
+func sourceMtime(metadata map[string]string, fallback time.Time) time.Time {
+	if stored, exists := metadata[mtimeMetadataKey]; exists {
+		if mtime, err := time.Parse(time.RFC3339, stored); err == nil {
+			return mtime
+		}
+	}
+	return fallback
+}
+
+// downloadFile writes an object to localPath through a temporary file in the same
+// directory, so readers never see a partially written file
+func downloadFile(ctx context.Context, backend Backend, cfg *SyncConfig, s3Key, localPath string) error {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, TempFilePrefix+"download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	// CreateTemp makes the file owner-only; match what a normal create would give
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+
+	var object *ObjectInfo
+	err = withRetry(ctx, cfg.MaxRetries, "download of "+s3Key, func() error {
+		if err := tmp.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		var content io.ReadCloser
+		var err error
+		content, object, err = backend.GetFile(ctx, s3Key)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		// Store gzip_extensions uploads as the original file
+		var body io.Reader = content
+		if object.ContentEncoding == contentEncodingGzip {
+			zr, err := gzip.NewReader(content)
+			if err != nil {
+				return err
+			}
+			body = zr
+		}
+		_, err = io.Copy(tmp, body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	mtime := sourceMtime(object.Metadata, object.LastModified)
+	if err := os.Chtimes(tmp.Name(), mtime, mtime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+// pullFromS3 downloads every object under the prefix into LocalDir, for restoring
+// into a fresh directory. Files that already match by size, and by ETag where it is
+// a content MD5, are skipped. Failed downloads are logged and returned as one error.
+func pullFromS3(ctx context.Context, backend Backend, cfg *SyncConfig) error {
+	remoteFiles, err := listS3Files(ctx, backend, cfg.Prefix, cfg.SyncMarkerFile)
+	if err != nil {
+		return fmt.Errorf("error listing s3://%s/%s: %w", cfg.BucketName, cfg.Prefix, err)
+	}
+	relPaths := make([]string, 0, len(remoteFiles))
+	for relPath := range remoteFiles {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	downloaded, skipped, failed := 0, 0, 0
+	for _, relPath := range relPaths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasSuffix(relPath, "/") || !filepath.IsLocal(filepath.FromSlash(relPath)) {
+			slog.Warn("Skipping key that doesn't map to a local file", "path", relPath)
+			continue
+		}
+		if !downloadable(cfg, relPath) {
+			continue
+		}
+
+		s3Key := objectKey(cfg.Prefix, relPath)
+		localPath := filepath.Join(cfg.LocalDir, filepath.FromSlash(relPath))
+		matches, err := localCopyMatches(ctx, backend, cfg, s3Key, localPath, remoteFiles[relPath])
+		if err != nil {
+			return err
+		}
+		if matches {
+			skipped++
+			continue
+		}
+
+		if cfg.DryRun {
+			slog.Info("[dry-run] Would download", "key", s3Key, "path", localPath)
+			downloaded++
+			continue
+		}
+		if err := downloadFile(ctx, backend, cfg, s3Key, localPath); err != nil {
+			slog.Error("Error downloading", "key", s3Key, "err", err)
+			failed++
+			continue
+		}
+		slog.Debug("Downloaded file", "key", s3Key, "path", localPath)
+		downloaded++
+	}
+
+	slog.Info("Pull complete", "downloaded", downloaded, "skipped", skipped, "failed", failed)
+	if failed > 0 {
+		return fmt.Errorf("failed to download %d of %d objects", failed, len(relPaths))
+	}
+	return nil
+}
+
+// localCopyMatches reports whether localPath already holds the object at s3Key: same
+// size and, unless the ETag is a multipart composite, the same MD5. Gzip-encoded
+// objects are compared by the uncompressed size and MD5 recorded at upload, and
+// never match when those weren't recorded. When SSE-KMS or SSE-C is configured the
+// ETag isn't an MD5, so objects are compared by the size and source mtime recorded
+// at upload instead.
+func localCopyMatches(ctx context.Context, backend Backend, cfg *SyncConfig, s3Key, localPath string, remote remoteObject) (bool, error) {
+	info, err := os.Stat(localPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	var sum string
+	etag := strings.Trim(remote.etag, "\"")
+	if etagIsMD5(cfg) && info.Size() == remote.size {
+		if isMultipartETag(etag) {
+			return true, nil
+		}
+		if sum, err = fileMD5(localPath); err != nil {
+			return false, err
+		}
+		if sum == etag {
+			return true, nil
+		}
+	}
+
+	if !etagIsMD5(cfg) {
+		head, err := backend.Head(ctx, s3Key)
+		if err != nil || head == nil {
+			return false, err
+		}
+		size, known := decodedSize(head)
+		if _, recorded := head.Metadata[mtimeMetadataKey]; !known || !recorded || size != info.Size() {
+			return false, nil
+		}
+		return !mtimeChanged(info.ModTime(), head.Metadata, cfg.MtimeTolerance), nil
+	}
+
+	// The listing has no Content-Encoding, so check whether the object is gzipped
+	head, err := backend.Head(ctx, s3Key)
+	if err != nil || head == nil || head.ContentEncoding != contentEncodingGzip {
+		return false, err
+	}
+	size, known := decodedSize(head)
+	if !known || size != info.Size() || head.Metadata[decodedMD5MetadataKey] == "" {
+		return false, nil
+	}
+	if sum == "" {
+		if sum, err = fileMD5(localPath); err != nil {
+			return false, err
+		}
+	}
+	return sum == head.Metadata[decodedMD5MetadataKey], nil
+}
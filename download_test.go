@@ -0,0 +1,222 @@
+package syncd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// localFiles lists the files under dir as slash-separated relative paths
+func localFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDownloadSkipsSyncdObjects(t *testing.T) {
+	dir := t.TempDir()
+	client := newFakeS3()
+	for _, key := range []string{
+		"data/a.txt",
+		"data/sub/b.txt",
+		"data/sub/syncd.txt",
+		"data/_SUCCESS",
+		"data/logs/20240101T000000Z.log",
+		"data/logs/20240101T000000Z.json",
+		"data/.trash/20240101T000000Z/old.txt",
+		"data/archive/kept.txt",
+	} {
+		client.put(key, []byte("remote"), nil)
+	}
+	cfg := testConfig(t, dir, map[string]string{
+		"direction":        "down",
+		"log_to_s3_prefix": "data/logs",
+		"trash_prefix":     "data/.trash",
+		"keep":             "archive/*",
+	})
+
+	if _, err := performFullSync(context.Background(), NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+		t.Fatalf("performFullSync: %v", err)
+	}
+	if got, want := localFiles(t, dir), []string{"a.txt", "sub/b.txt"}; !slices.Equal(got, want) {
+		t.Errorf("downloaded %v, want %v", got, want)
+	}
+}
+
+func TestDownloadComparesGzippedObjectsByMtime(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"data.json": `{"compressible": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`})
+	client := newFakeS3()
+	cfg := testConfig(t, dir, map[string]string{"gzip_extensions": ".json", "direction": "both", "conflict": "remote"})
+
+	if _, err := performFullSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if obj := client.object("data/data.json"); obj == nil || obj.contentEncoding != contentEncodingGzip {
+		t.Fatal("data.json wasn't uploaded gzipped")
+	}
+
+	// The compressed size differs from the local file, but the mtime matches
+	client.gets = 0
+	if _, err := performFullSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if client.gets != 0 {
+		t.Errorf("second sync downloaded %d objects, want 0", client.gets)
+	}
+}
+
+func TestPullComparesGzippedObjects(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"data.json": `{"compressible": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`})
+	client := newFakeS3()
+	cfg := testConfig(t, dir, map[string]string{"gzip_extensions": ".json"})
+
+	if _, err := performFullSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+		t.Fatalf("performFullSync: %v", err)
+	}
+	obj := client.object("data/data.json")
+	if obj == nil || obj.metadata[decodedSizeMetadataKey] == "" || obj.metadata[decodedMD5MetadataKey] == "" {
+		t.Fatal("data.json wasn't uploaded with its uncompressed size and MD5")
+	}
+
+	// The local file matches the recorded uncompressed size and MD5
+	if err := pullFromS3(ctx, NewS3Backend(client, cfg), cfg); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if client.gets != 0 {
+		t.Errorf("Pull downloaded %d objects, want 0", client.gets)
+	}
+
+	// Without them the object is downloaded again
+	delete(obj.metadata, decodedSizeMetadataKey)
+	delete(obj.metadata, decodedMD5MetadataKey)
+	if err := pullFromS3(ctx, NewS3Backend(client, cfg), cfg); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if client.gets != 1 {
+		t.Errorf("Pull downloaded %d objects, want 1", client.gets)
+	}
+}
+
+func TestPullSkipsSyncdObjects(t *testing.T) {
+	dir := t.TempDir()
+	client := newFakeS3()
+	for _, key := range []string{
+		"data/a.txt",
+		"data/a.txt.syncdmeta",
+		"data/sub/b.txt",
+		"data/sub/skip.tmp",
+		"data/_SUCCESS",
+		"data/logs/20240101T000000Z.log",
+		"data/.trash/20240101T000000Z/old.txt",
+	} {
+		client.put(key, []byte("remote"), nil)
+	}
+	cfg := testConfig(t, dir, map[string]string{
+		"log_to_s3_prefix": "data/logs",
+		"trash_prefix":     "data/.trash",
+		"exclude":          "*.tmp",
+	})
+
+	if err := pullFromS3(context.Background(), NewS3Backend(client, cfg), cfg); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got, want := localFiles(t, dir), []string{"a.txt", "sub/b.txt"}; !slices.Equal(got, want) {
+		t.Errorf("pulled %v, want %v", got, want)
+	}
+}
+
+func TestPullComparesKMSObjectsByMtime(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "content"})
+	client := newFakeS3()
+	cfg := testConfig(t, dir, map[string]string{"sse": "aws:kms"})
+
+	if _, err := performFullSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+		t.Fatalf("performFullSync: %v", err)
+	}
+	// SSE-KMS ETags look like an MD5 but aren't the content MD5
+	obj := client.object("data/a.txt")
+	obj.etag = `"0123456789abcdef0123456789abcdef"`
+
+	if err := pullFromS3(ctx, NewS3Backend(client, cfg), cfg); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if client.gets != 0 {
+		t.Errorf("Pull downloaded %d objects, want 0", client.gets)
+	}
+
+	// A local mtime that differs from the recorded one means the copies differ
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := pullFromS3(ctx, NewS3Backend(client, cfg), cfg); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if client.gets != 1 {
+		t.Errorf("Pull downloaded %d objects, want 1", client.gets)
+	}
+}
+
+func TestBothUploadsLocalEdits(t *testing.T) {
+	tests := []struct {
+		conflict   string
+		wantRemote string
+		wantLocal  string
+	}{
+		{conflictNewer, "version2", "version2"},
+		{conflictLocal, "version2", "version2"},
+		{conflictRemote, "v1", "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.conflict, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "v1"})
+			client := newFakeS3()
+			cfg := testConfig(t, dir, map[string]string{"direction": "both", "conflict": tt.conflict})
+
+			if _, err := performFullSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+				t.Fatalf("first sync: %v", err)
+			}
+
+			// Edit the file locally, an hour after it was uploaded
+			path := filepath.Join(dir, "a.txt")
+			writeFiles(t, dir, map[string]string{"a.txt": "version2"})
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(path, later, later); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := performFullSync(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+				t.Fatalf("second sync: %v", err)
+			}
+
+			if obj := client.object("data/a.txt"); obj == nil || string(obj.body) != tt.wantRemote {
+				t.Errorf("remote copy = %+v, want %q", obj, tt.wantRemote)
+			}
+			if content, err := os.ReadFile(path); err != nil || string(content) != tt.wantLocal {
+				t.Errorf("local copy = %q, %v; want %q", content, err, tt.wantLocal)
+			}
+		})
+	}
+}
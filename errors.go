@@ -0,0 +1,83 @@
+package syncd
+
+import (
+	"errors"
+
+	"github.com/aws/smithy-go"
+)
+
+// fatalErrorCodes are S3 API error codes that retrying or restarting won't fix
+var fatalErrorCodes = map[string]bool{
+	"AccessDenied":                 true,
+	"AllAccessDisabled":            true,
+	"AuthorizationHeaderMalformed": true,
+	"ExpiredToken":                 true,
+	"InvalidAccessKeyId":           true,
+	"InvalidBucketName":            true,
+	"InvalidToken":                 true,
+	"NoSuchBucket":                 true,
+	"PermanentRedirect":            true,
+	"SignatureDoesNotMatch":        true,
+}
+
+// configError marks failures caused by invalid configuration or local setup
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string { return e.err.Error() }
+
+func (e *configError) Unwrap() error { return e.err }
+
+// partialError marks a sync that finished but had per-file failures (continue_on_error)
+type partialError struct {
+	err error
+}
+
+func (e *partialError) Error() string { return e.err.Error() }
+
+func (e *partialError) Unwrap() error { return e.err }
+
+// fileError is a per-file failure collected with continue_on_error
+type fileError struct {
+	path string
+	err  error
+}
+
+func (e *fileError) Error() string { return e.path + ": " + e.err.Error() }
+
+func (e *fileError) Unwrap() error { return e.err }
+
+// stsError marks a failed STS AssumeRole call, so credential failures aren't
+// mistaken for S3 errors
+type stsError struct {
+	roleARN string
+	err     error
+}
+
+func (e *stsError) Error() string {
+	return "STS AssumeRole for " + e.roleARN + " failed: " + e.err.Error()
+}
+
+func (e *stsError) Unwrap() error { return e.err }
+
+// IsPartial reports whether err is from a sync that ran to completion with
+// continue_on_error but some files failed
+func IsPartial(err error) bool {
+	var partialErr *partialError
+	return errors.As(err, &partialErr)
+}
+
+// IsFatal reports whether err is a config or auth failure that won't go away on its own.
+// Everything else (network errors, throttling, 5xx) is treated as transient.
+func IsFatal(err error) bool {
+	var cfgErr *configError
+	if errors.As(err, &cfgErr) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return fatalErrorCodes[apiErr.ErrorCode()]
+	}
+	return false
+}
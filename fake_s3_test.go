@@ -0,0 +1,251 @@
+package syncd
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeObject is an object stored by fakeS3
+type fakeObject struct {
+	body            []byte
+	etag            string
+	metadata        map[string]string
+	lastModified    time.Time
+	contentType     string
+	contentEncoding string
+	cacheControl    string
+}
+
+// fakeS3 is an in-memory S3API for a single bucket. Multipart uploads aren't
+// supported; keep test files under multipart_threshold.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	// Keys left out of listings, like a store whose listings lag behind writes
+	unlisted map[string]bool
+	// Error returned by HeadObject, if set, before the object is looked up
+	headErr func(key string) error
+	// Error returned by every ListObjectsV2 call, if set
+	listErr error
+	// Most keys per ListObjectsV2 page, or 0 for a single page
+	pageSize int
+	// Number of HeadObject, GetObject and ListObjectsV2 calls, including failed ones
+	heads, gets, lists int
+}
+
+var _ S3API = (*fakeS3)(nil)
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string]*fakeObject), unlisted: make(map[string]bool)}
+}
+
+// put stores an object directly, as if uploaded earlier
+func (f *fakeS3) put(key string, body []byte, metadata map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = &fakeObject{body: body, etag: fakeETag(body), metadata: metadata, lastModified: time.Now()}
+}
+
+// keys returns the stored keys in lexical order
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) object(key string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key]
+}
+
+func fakeETag(body []byte) string {
+	sum := md5.Sum(body)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if params.Body != nil {
+		var err error
+		if body, err = io.ReadAll(params.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj := &fakeObject{
+		body:            body,
+		etag:            fakeETag(body),
+		metadata:        maps.Clone(params.Metadata),
+		lastModified:    time.Now(),
+		contentType:     aws.ToString(params.ContentType),
+		contentEncoding: aws.ToString(params.ContentEncoding),
+		cacheControl:    aws.ToString(params.CacheControl),
+	}
+	f.objects[aws.ToString(params.Key)] = obj
+	return &s3.PutObjectOutput{ETag: aws.String(obj.etag)}, nil
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	key := aws.ToString(params.Key)
+	f.mu.Lock()
+	f.heads++
+	headErr := f.headErr
+	f.mu.Unlock()
+	if headErr != nil {
+		if err := headErr(key); err != nil {
+			return nil, err
+		}
+	}
+
+	obj := f.object(key)
+	if obj == nil {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength:   aws.Int64(int64(len(obj.body))),
+		ETag:            aws.String(obj.etag),
+		LastModified:    aws.Time(obj.lastModified),
+		Metadata:        obj.metadata,
+		ContentType:     optionalString(obj.contentType),
+		ContentEncoding: optionalString(obj.contentEncoding),
+		CacheControl:    optionalString(obj.cacheControl),
+	}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	f.gets++
+	f.mu.Unlock()
+	obj := f.object(aws.ToString(params.Key))
+	if obj == nil {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{
+		Body:            io.NopCloser(bytes.NewReader(obj.body)),
+		ContentLength:   aws.Int64(int64(len(obj.body))),
+		ETag:            aws.String(obj.etag),
+		LastModified:    aws.Time(obj.lastModified),
+		Metadata:        obj.metadata,
+		ContentEncoding: optionalString(obj.contentEncoding),
+	}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	f.lists++
+	f.mu.Unlock()
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	// The continuation token is the last key of the previous page
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for _, key := range f.keys() {
+		if !strings.HasPrefix(key, aws.ToString(params.Prefix)) || f.unlisted[key] || key <= aws.ToString(params.ContinuationToken) {
+			continue
+		}
+		if f.pageSize > 0 && len(output.Contents) == f.pageSize {
+			output.IsTruncated = aws.Bool(true)
+			output.NextContinuationToken = output.Contents[len(output.Contents)-1].Key
+			break
+		}
+		obj := f.object(key)
+		output.Contents = append(output.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.body))),
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(obj.lastModified),
+		})
+	}
+	return output, nil
+}
+
+func (f *fakeS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := &s3.DeleteObjectsOutput{}
+	for _, obj := range params.Delete.Objects {
+		delete(f.objects, aws.ToString(obj.Key))
+		output.Deleted = append(output.Deleted, types.DeletedObject{Key: obj.Key})
+	}
+	return output, nil
+}
+
+func (f *fakeS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	source, err := url.PathUnescape(aws.ToString(params.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	_, sourceKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	obj := f.object(sourceKey)
+	if obj == nil {
+		return nil, &types.NoSuchKey{}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copied := *obj
+	copied.lastModified = time.Now()
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		copied.metadata = maps.Clone(params.Metadata)
+		copied.contentType = aws.ToString(params.ContentType)
+		copied.contentEncoding = aws.ToString(params.ContentEncoding)
+		copied.cacheControl = aws.ToString(params.CacheControl)
+	}
+	f.objects[aws.ToString(params.Key)] = &copied
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (f *fakeS3) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	return &s3.ListMultipartUploadsOutput{}, nil
+}
+
+var errFakeMultipart = errors.New("fakeS3 doesn't support multipart uploads")
+
+func (f *fakeS3) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return nil, errFakeMultipart
+}
+
+func (f *fakeS3) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	return nil, errFakeMultipart
+}
+
+func (f *fakeS3) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return nil, errFakeMultipart
+}
+
+func (f *fakeS3) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return nil, errFakeMultipart
+}
@@ -0,0 +1,115 @@
+package syncd
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitignoreRule is one pattern line from a .gitignore file
+type gitignoreRule struct {
+	pattern *globPattern
+	negate  bool // "!pattern" re-includes a previously ignored path
+	dirOnly bool // "pattern/" only matches directories
+}
+
+// parseGitignore reads a .gitignore file. A missing file yields no rules.
+func parseGitignore(filename string) ([]gitignoreRule, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []gitignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule gitignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// Escaped leading "#" or "!"
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		// A slash at the start or in the middle anchors the pattern to the .gitignore's directory
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		pattern, err := compileGlob(line)
+		if err != nil {
+			slog.Warn("Ignoring invalid .gitignore pattern", "path", filename, "err", err)
+			continue
+		}
+		if anchored {
+			pattern.basename = false
+		}
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// gitignored reports whether relPath is ignored by the .gitignore files in its
+// ancestor directories. Deeper files override shallower ones and later lines
+// override earlier ones, as in git.
+func (w *walkFilter) gitignored(relPath string, isDir bool) bool {
+	ignored := false
+	dir := "."
+	parts := strings.Split(path.Dir(relPath), "/")
+	for i := 0; ; i++ {
+		// Match relative to the directory holding the .gitignore
+		target := relPath
+		if dir != "." {
+			target = strings.TrimPrefix(relPath, dir+"/")
+		}
+		for _, rule := range w.gitignoreRules(dir) {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.pattern.match(target) {
+				ignored = !rule.negate
+			}
+		}
+
+		if i >= len(parts) || parts[i] == "." {
+			break
+		}
+		dir = path.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+// gitignoreRules returns the rules of the .gitignore in a directory, loading it on first use
+func (w *walkFilter) gitignoreRules(dir string) []gitignoreRule {
+	if rules, loaded := w.gitignores[dir]; loaded {
+		return rules
+	}
+
+	filename := filepath.Join(w.cfg.LocalDir, filepath.FromSlash(dir), ".gitignore")
+	rules, err := parseGitignore(filename)
+	if err != nil {
+		slog.Error("Error reading .gitignore", "path", filename, "err", err)
+	}
+	w.gitignores[dir] = rules
+	return rules
+}
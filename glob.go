@@ -0,0 +1,111 @@
+package syncd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// globPattern is a compiled exclude/include pattern. "*" and "?" stay within
+// one path element, "**" spans directories, and patterns without a "/" match
+// the last element of a path anywhere in the tree (like .gitignore). Patterns
+// ending in "/" match directories, and with them everything below.
+type globPattern struct {
+	raw      string
+	re       *regexp.Regexp
+	basename bool
+	dir      bool
+}
+
+func (g *globPattern) String() string {
+	return g.raw
+}
+
+// compileGlob turns a glob into an anchored regular expression
+func compileGlob(raw string) (*globPattern, error) {
+	pattern := strings.TrimSuffix(raw, "/")
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				expr.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q: unclosed [", raw)
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", raw, err)
+	}
+	return &globPattern{raw: raw, re: re, basename: !strings.Contains(pattern, "/"), dir: pattern != raw}, nil
+}
+
+// compileGlobs compiles a comma-separated list of patterns
+func compileGlobs(value string) ([]*globPattern, error) {
+	var patterns []*globPattern
+	for _, raw := range splitList(value) {
+		pattern, err := compileGlob(raw)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// match reports whether a slash-separated relative path matches the pattern. A
+// path ending in "/" is a directory.
+func (g *globPattern) match(relPath string) bool {
+	if !g.dir {
+		return g.matchPath(strings.TrimSuffix(relPath, "/"))
+	}
+	// Directory patterns match any directory the path lies in
+	for i := range len(relPath) {
+		if relPath[i] == '/' && g.matchPath(relPath[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *globPattern) matchPath(relPath string) bool {
+	if g.basename {
+		return g.re.MatchString(path.Base(relPath))
+	}
+	return g.re.MatchString(relPath)
+}
+
+// matchAny reports whether relPath matches any of the patterns
+func matchAny(patterns []*globPattern, relPath string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(relPath) {
+			return true
+		}
+	}
+	return false
+}
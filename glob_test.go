@@ -0,0 +1,83 @@
+package syncd
+
+import "testing"
+
+func TestGlobPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		// Patterns without a "/" match the last element anywhere
+		{"*.tmp", "a.tmp", true},
+		{"*.tmp", "sub/dir/a.tmp", true},
+		{"*.tmp", "a.tmp.txt", false},
+		{".DS_Store", "photos/.DS_Store", true},
+		{"?.txt", "sub/a.txt", true},
+		{"?.txt", "ab.txt", false},
+
+		// "*" stays within one element, "**/" spans zero or more directories
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/sub/a.md", false},
+		{"**/*.log", "a.log", true},
+		{"**/*.log", "logs/2024/a.log", true},
+		{"docs/**/*.md", "docs/a.md", true},
+		{"docs/**/*.md", "docs/x/y/a.md", true},
+		{"docs/**/*.md", "other/docs/a.md", false},
+		{"docs/**", "docs/x/y/a.md", true},
+
+		// Character classes
+		{"[ab].txt", "a.txt", true},
+		{"[ab].txt", "c.txt", false},
+		{"[!ab].txt", "c.txt", true},
+		{"[!ab].txt", "a.txt", false},
+		{"file[0-9].txt", "dir/file7.txt", true},
+
+		// Trailing-slash patterns match directories and what's below them
+		{"build/", "build/out.bin", true},
+		{"build/", "sub/build/out.bin", true},
+		{"build/", "build", false},
+		{"build/", "build/", true},
+		{"build/", "rebuild/out.bin", false},
+		{"docs/build/", "docs/build/x/out.bin", true},
+		{"docs/build/", "build/out.bin", false},
+	}
+	for _, tt := range tests {
+		pattern, err := compileGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", tt.pattern, err)
+		}
+		if got := pattern.match(tt.path); got != tt.want {
+			t.Errorf("%q.match(%q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGlobRejectsUnclosedClass(t *testing.T) {
+	if _, err := compileGlob("file[0-9.txt"); err == nil {
+		t.Error("compileGlob accepted an unclosed [")
+	}
+}
+
+func TestExcludeWinsOverInclude(t *testing.T) {
+	cfg := testConfig(t, t.TempDir(), map[string]string{
+		"include": "*.jpg,docs/",
+		"exclude": "private/,*.tmp.jpg",
+	})
+	w := newWalkFilter(cfg)
+	for relPath, want := range map[string]bool{
+		"a.jpg":              false,
+		"private/a.jpg":      true,
+		"a.tmp.jpg":          true,
+		"docs/readme.md":     false,
+		"docs/private/a.jpg": true,
+		"notes.txt":          true,
+	} {
+		if got := w.filteredOut(relPath); got != want {
+			t.Errorf("filteredOut(%q) = %v, want %v", relPath, got, want)
+		}
+		if got := !downloadable(cfg, relPath); got != want {
+			t.Errorf("downloadable(%q) = %v, want %v", relPath, !got, !want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package syncd
+
+import (
+	"io"
+	"log/slog"
+)
+
+// NewLogger returns a logger writing cfg.LogFormat records at cfg.LogLevel and
+// above to w. Install it with slog.SetDefault, writing to RunLog so that
+// log_to_s3_prefix can capture run logs.
+func NewLogger(w io.Writer, cfg *SyncConfig) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
@@ -0,0 +1,113 @@
+package syncd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"time"
+)
+
+// manifest is the JSON marker written with manifest_mode, describing the files
+// directly inside a subdirectory as they were when it was verified
+type manifest struct {
+	SyncedAt string          `json:"synced_at"`
+	Files    []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	Path  string `json:"path"` // relative to LocalDir
+	Size  int64  `json:"size"`
+	Mtime string `json:"mtime"` // RFC3339, whole seconds
+	MD5   string `json:"md5"`
+}
+
+// unchanged reports whether f still matches the entry by size and mtime
+func (e manifestEntry) unchanged(f *localFile) bool {
+	return e.Size == f.size() && e.Mtime == formatMtime(f.info.ModTime())
+}
+
+// loadManifests reads every manifest marker under the prefix into a map keyed by
+// relative path. Plain-text markers from runs without manifest_mode are ignored.
+func loadManifests(ctx context.Context, backend Backend, cfg *SyncConfig) (map[string]manifestEntry, error) {
+	objects, err := backend.List(ctx, cfg.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]manifestEntry)
+	for _, obj := range objects {
+		if path.Base(obj.Key) != cfg.SyncMarkerFile {
+			continue
+		}
+		m, err := readManifest(ctx, backend, obj.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading manifest s3://%s/%s: %w", cfg.BucketName, obj.Key, err)
+		}
+		for _, entry := range m.Files {
+			entries[entry.Path] = entry
+		}
+	}
+	return entries, nil
+}
+
+// readManifest fetches and parses one marker, returning an empty manifest for non-JSON markers
+func readManifest(ctx context.Context, backend Backend, key string) (*manifest, error) {
+	body, err := backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		slog.Warn("Ignoring marker that isn't a manifest", "key", key)
+		return &manifest{}, nil
+	}
+	return m, nil
+}
+
+// recordManifest adds f to this run's manifest, reusing the previous entry's MD5
+// when the file is unchanged so only new or modified files are hashed
+func (s *syncState) recordManifest(f *localFile) error {
+	entry, exists := s.oldManifest[f.relPath]
+	if !exists || !entry.unchanged(f) {
+		sum, err := localMD5(f)
+		if err != nil {
+			return err
+		}
+		entry = manifestEntry{
+			Path:  f.relPath,
+			Size:  f.size(),
+			Mtime: formatMtime(f.info.ModTime()),
+			MD5:   sum,
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manifest[f.relPath] = entry
+	return nil
+}
+
+// manifestFor builds the manifest of the files directly inside subdir
+func (s *syncState) manifestFor(subdir string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := manifest{SyncedAt: time.Now().Format(time.RFC3339), Files: []manifestEntry{}}
+	for relPath, entry := range s.manifest {
+		if path.Dir(relPath) == subdir {
+			m.Files = append(m.Files, entry)
+		}
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	return json.MarshalIndent(m, "", "  ")
+}
@@ -0,0 +1,64 @@
+package syncd
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Sync metrics, registered with the default Prometheus registry and updated at
+// the end of every full sync
+var (
+	filesUploadedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "syncd_files_uploaded_total",
+		Help: "Files uploaded to S3.",
+	})
+	filesDeletedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "syncd_files_deleted_total",
+		Help: "Objects deleted from S3.",
+	})
+	syncErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "syncd_sync_errors_total",
+		Help: "Syncs that ended in an error.",
+	})
+	syncDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "syncd_sync_duration_seconds",
+		Help:    "Duration of full syncs.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14), // 1s to ~2h
+	})
+	lastSuccessTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "syncd_last_success_timestamp",
+		Help: "Unix time of the last sync that completed without error.",
+	})
+	circuitOpenTargets = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "syncd_circuit_breaker_open",
+		Help: "Targets whose syncs are currently backed off after repeated failures.",
+	})
+	circuitOpenedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "syncd_circuit_breaker_opened_total",
+		Help: "Times a target started backing off after repeated failed syncs.",
+	})
+)
+
+// recordMetrics adds a finished sync to the Prometheus metrics
+func recordMetrics(result SyncResult, err error) {
+	filesUploadedTotal.Add(float64(result.FilesUploaded))
+	filesDeletedTotal.Add(float64(result.FilesDeleted))
+	syncDurationSeconds.Observe(result.Duration.Seconds())
+	if err != nil {
+		syncErrorsTotal.Inc()
+		return
+	}
+	lastSuccessTimestamp.SetToCurrentTime()
+}
+
+// RecordCircuitOpened updates the metrics when a target starts backing off
+// after failure_threshold consecutive failed syncs
+func RecordCircuitOpened() {
+	circuitOpenTargets.Inc()
+	circuitOpenedTotal.Inc()
+}
+
+// RecordCircuitClosed updates the metrics when a backed-off target syncs successfully again
+func RecordCircuitClosed() {
+	circuitOpenTargets.Dec()
+}
@@ -0,0 +1,25 @@
+package syncd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsCountFailedListing(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+	client := newFakeS3()
+	client.listErr = httpError(403, &smithy.GenericAPIError{Code: "AccessDenied"})
+	cfg := testConfig(t, dir, nil)
+
+	errors := testutil.ToFloat64(syncErrorsTotal)
+	if _, err := performFullSync(context.Background(), NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err == nil {
+		t.Fatal("performFullSync succeeded with a failing listing, want an error")
+	}
+	if got := testutil.ToFloat64(syncErrorsTotal); got != errors+1 {
+		t.Errorf("syncd_sync_errors_total = %v, want %v", got, errors+1)
+	}
+}
@@ -0,0 +1,98 @@
+package syncd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// abortTimeout bounds aborting a multipart upload after shutdown was requested
+const abortTimeout = 30 * time.Second
+
+// uploadMultipart uploads input in cfg.PartSize parts. Unlike a single PUT it stops
+// at shutdown rather than running to completion, and a failed or canceled upload
+// is aborted so its parts don't keep accruing storage charges.
+func uploadMultipart(ctx context.Context, client S3API, cfg *SyncConfig, input *s3.PutObjectInput) error {
+	// The uploader reads parts straight from the file, so it isn't buffered in memory.
+	// It would abort with the already-canceled ctx, so parts are left for us to abort.
+	// The client makes a single attempt per request, so give each request of the
+	// upload max_retries of its own; callers don't retry the upload as a whole.
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = cfg.PartSize
+		u.LeavePartsOnError = true
+		u.ClientOptions = append(u.ClientOptions, func(o *s3.Options) {
+			o.RetryMaxAttempts = cfg.MaxRetries + 1
+		})
+	})
+	_, err := uploader.Upload(ctx, input)
+
+	var failure manager.MultiUploadFailure
+	if errors.As(err, &failure) && failure.UploadID() != "" {
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+		defer cancel()
+		if abortErr := abortMultipartUpload(abortCtx, client, cfg, aws.ToString(input.Key), failure.UploadID()); abortErr != nil {
+			slog.Error("Error aborting multipart upload", "key", aws.ToString(input.Key), "err", abortErr)
+		}
+	}
+	return err
+}
+
+// abortMultipartUpload discards the parts of an incomplete multipart upload
+func abortMultipartUpload(ctx context.Context, client S3API, cfg *SyncConfig, key, uploadID string) error {
+	_, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &cfg.BucketName,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
+	return err
+}
+
+// abortStaleMultiparts aborts incomplete multipart uploads under the prefix that were
+// started more than olderThan ago, such as those left by a killed process.
+// Failures to abort individual uploads are logged and skipped.
+func abortStaleMultiparts(ctx context.Context, client S3API, cfg *SyncConfig, olderThan time.Duration) error {
+	cutoff := time.Now().Add(-olderThan)
+
+	aborted := 0
+	paginator := s3.NewListMultipartUploadsPaginator(client, &s3.ListMultipartUploadsInput{
+		Bucket: &cfg.BucketName,
+		Prefix: &cfg.Prefix,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, upload := range output.Uploads {
+			if !aws.ToTime(upload.Initiated).Before(cutoff) {
+				continue
+			}
+			key := aws.ToString(upload.Key)
+			if cfg.DryRun {
+				slog.Info("[dry-run] Would abort stale multipart upload", "key", key, "initiated", aws.ToTime(upload.Initiated))
+				aborted++
+				continue
+			}
+
+			opCtx, cancel := operationContext(ctx, cfg)
+			err := abortMultipartUpload(opCtx, client, cfg, key, aws.ToString(upload.UploadId))
+			cancel()
+			if err != nil {
+				slog.Error("Error aborting stale multipart upload", "key", key, "err", err)
+				continue
+			}
+			slog.Debug("Aborted stale multipart upload", "key", key, "initiated", aws.ToTime(upload.Initiated))
+			aborted++
+		}
+	}
+
+	if aborted > 0 {
+		slog.Info("Aborted stale multipart uploads", "count", aborted)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package syncd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// utf8BOM is the byte order mark some editors prepend to UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// normalizeText strips a leading UTF-8 BOM and converts CRLF line endings to LF
+func normalizeText(content []byte) []byte {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// looksBinary reports whether content contains a NUL byte, which text files never do
+func looksBinary(content []byte) bool {
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// loadContent prepares what will be uploaded for f: text is normalized when its
+// extension is listed in normalize_text (binary content is left alone), then gzipped
+// when it is listed in gzip_extensions. The result is cached on f so every comparison
+// and the upload itself see the same bytes.
+func loadContent(cfg *SyncConfig, f *localFile) error {
+	if f.contentLoaded {
+		return nil
+	}
+	f.contentLoaded = true
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(f.relPath), "."))
+	normalize, compress := cfg.NormalizeText[ext], cfg.GzipExtensions[ext]
+	if !normalize && !compress {
+		return nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	transformed := false
+	if normalize && !looksBinary(content) {
+		content = normalizeText(content)
+		transformed = true
+	}
+	if compress {
+		sum := md5.Sum(content)
+		f.decodedSize, f.decodedMD5 = int64(len(content)), hex.EncodeToString(sum[:])
+		if content, err = gzipContent(content); err != nil {
+			return err
+		}
+		f.gzipped = true
+		transformed = true
+	}
+	if transformed {
+		f.content = content
+	}
+	return nil
+}
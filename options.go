@@ -0,0 +1,83 @@
+package syncd
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+)
+
+// Option sets one config key for NewSyncerWithOptions. Options write the same
+// key=value settings a config file holds, so they get the same defaults and validation.
+type Option func(configMap map[string]string)
+
+// NewSyncerWithOptions builds a config from opts, applied in order, and returns a
+// Syncer for it. local_dir and bucket_name are required, as in a config file.
+func NewSyncerWithOptions(client S3API, opts ...Option) (*Syncer, error) {
+	configMap := make(map[string]string)
+	for _, opt := range opts {
+		opt(configMap)
+	}
+	cfg, err := parseConfig(configMap)
+	if err != nil {
+		return nil, err
+	}
+	return NewSyncer(client, cfg), nil
+}
+
+// WithSetting sets any config key to value as written in a config file
+func WithSetting(key, value string) Option {
+	return func(configMap map[string]string) {
+		configMap[key] = value
+	}
+}
+
+// WithLocalDir sets local_dir
+func WithLocalDir(dir string) Option {
+	return WithSetting("local_dir", dir)
+}
+
+// WithBucket sets bucket_name
+func WithBucket(bucket string) Option {
+	return WithSetting("bucket_name", bucket)
+}
+
+// WithPrefix sets prefix
+func WithPrefix(prefix string) Option {
+	return WithSetting("prefix", prefix)
+}
+
+// WithConcurrency sets concurrency
+func WithConcurrency(n int) Option {
+	return WithSetting("concurrency", strconv.Itoa(n))
+}
+
+// WithDryRun sets dry_run
+func WithDryRun(dryRun bool) Option {
+	return WithSetting("dry_run", strconv.FormatBool(dryRun))
+}
+
+// ConfigFileOptions reads a single-target config file into options, so a file can
+// be combined with options that override it. Unlike ReadConfigFile, SYNCD_*
+// environment variables are not applied.
+func ConfigFileOptions(path string) ([]Option, error) {
+	readSections := readConfigSections
+	if isYAMLConfig(path) {
+		readSections = readYAMLConfigSections
+	}
+	shared, sections, err := readSections(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(sections) > 1 {
+		return nil, fmt.Errorf("config file defines %d targets, expected one", len(sections))
+	}
+	if len(sections) == 1 {
+		maps.Copy(shared, sections[0].values)
+	}
+
+	opts := make([]Option, 0, len(shared))
+	for key, value := range shared {
+		opts = append(opts, WithSetting(key, value))
+	}
+	return opts, nil
+}
@@ -0,0 +1,57 @@
+package syncd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// bytesPerGB is used for cost estimates, matching how AWS bills storage
+const bytesPerGB = 1 << 30
+
+// planSync runs a read-only dry-run sync and writes the API calls, bytes and
+// rough cost it would involve to w
+func planSync(ctx context.Context, backend Backend, cfg *SyncConfig, failedSubdirs *subdirSet, w io.Writer) error {
+	// Plan against a dry-run copy so nothing in the bucket is modified
+	planCfg := *cfg
+	planCfg.DryRun = true
+
+	state, err := newSyncState(ctx, backend, &planCfg, failedSubdirs)
+	if err != nil {
+		return fmt.Errorf("error preparing plan: %v", err)
+	}
+	if err := syncDirectoryToS3(ctx, &planCfg, state); err != nil {
+		return fmt.Errorf("error planning sync: %v", err)
+	}
+	if err := deleteRemoved(ctx, &planCfg, state); err != nil {
+		return fmt.Errorf("error planning deletes: %v", err)
+	}
+
+	// ListObjectsV2 returns up to 1000 keys per page; the prefix is listed
+	// once up front and once more to verify the upload
+	lists := len(state.remoteFiles)/1000 + 1 + (len(state.remoteFiles)+state.uploaded)/1000 + 1
+	puts := state.uploaded + state.markers
+	if cfg.SuccessMarker {
+		puts++
+	}
+	// DeleteObjects removes up to maxDeleteBatch keys per request
+	deletes := (state.deleted + maxDeleteBatch - 1) / maxDeleteBatch
+	gb := float64(state.uploadedBytes) / bytesPerGB
+
+	requestCost := float64(puts)/1000*cfg.CostPer1kPut +
+		float64(lists)/1000*cfg.CostPer1kList +
+		float64(state.headRequests)/1000*cfg.CostPer1kHead
+	storageCost := gb * cfg.CostPerGB
+
+	fmt.Fprintf(w, "Plan for s3://%s/%s\n", cfg.BucketName, cfg.Prefix)
+	fmt.Fprintf(w, "  PUT requests:    %d (%d files, %d markers)\n", puts, state.uploaded, puts-state.uploaded)
+	fmt.Fprintf(w, "  DELETE requests: %d (%d objects)\n", deletes, state.deleted)
+	fmt.Fprintf(w, "  LIST requests:   %d (estimated)\n", lists)
+	fmt.Fprintf(w, "  HEAD requests:   %d\n", state.headRequests)
+	fmt.Fprintf(w, "  Upload size:     %d bytes (%.3f GB)\n", state.uploadedBytes, gb)
+	fmt.Fprintf(w, "  Estimated cost:  $%.4f in requests, $%.4f/month to store new data\n", requestCost, storageCost)
+
+	slog.Info("Plan complete, no changes were made")
+	return nil
+}
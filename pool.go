@@ -0,0 +1,174 @@
+package syncd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// uploadPool runs upload jobs on at most size goroutines. The first job to fail
+// cancels the pool's context so jobs that haven't started are dropped.
+type uploadPool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	err    error
+
+	// adaptive_concurrency: limit is the current worker count. Slots above it are
+	// held in sem, or owed until a running job frees one.
+	adaptive   bool
+	errorRate  float64
+	window     int
+	limit      int
+	held, owed int
+	requests   int
+	failures   int
+}
+
+func newUploadPool(ctx context.Context, size int) *uploadPool {
+	ctx, cancel := context.WithCancel(ctx)
+	return &uploadPool{ctx: ctx, cancel: cancel, sem: make(chan struct{}, size), limit: size}
+}
+
+// adapt makes the pool drop to half its workers, down to 1, whenever more than
+// errorRate of the last window S3 requests were throttled or failed with a 5xx,
+// and add one back after each window below errorRate
+func (p *uploadPool) adapt(errorRate float64, window int) {
+	p.adaptive, p.errorRate, p.window = true, errorRate, window
+}
+
+// Go blocks until a worker is free and runs job on it. It returns an error
+// instead once the pool has been canceled by a failure or by shutdown.
+func (p *uploadPool) Go(job func(ctx context.Context) error) error {
+	select {
+	case p.sem <- struct{}{}:
+	case <-p.ctx.Done():
+		return p.Wait()
+	}
+	// Both cases may have been ready; don't start new work after cancellation
+	if p.ctx.Err() != nil {
+		<-p.sem
+		return p.Wait()
+	}
+
+	ctx := p.ctx
+	if p.adaptive {
+		ctx = context.WithValue(ctx, requestObserverKey{}, p)
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.release()
+
+		if err := job(ctx); err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+			p.cancel()
+		}
+	}()
+	return nil
+}
+
+// release frees a finished job's worker slot, or keeps it if the pool owes one
+func (p *uploadPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.owed > 0 {
+		p.owed--
+		p.held++
+		return
+	}
+	<-p.sem
+}
+
+// observe counts the outcome of an S3 request made by one of the pool's jobs and
+// resizes the pool once the error rate is known
+func (p *uploadPool) observe(err error) {
+	if err != nil && !isOverloaded(err) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests++
+	if err != nil {
+		p.failures++
+	}
+
+	switch {
+	case float64(p.failures) > p.errorRate*float64(p.window):
+		// Shrink as soon as the window can't end below errorRate
+		if p.limit > 1 {
+			p.limit = max(1, p.limit/2)
+			for p.limit+p.held+p.owed < cap(p.sem) {
+				select {
+				case p.sem <- struct{}{}:
+					p.held++
+				default:
+					p.owed++
+				}
+			}
+			slog.Warn("S3 is throttling or failing requests, reducing upload concurrency", "concurrency", p.limit)
+		}
+	case p.requests >= p.window:
+		if p.limit < cap(p.sem) {
+			p.limit++
+			if p.owed > 0 {
+				p.owed--
+			} else {
+				<-p.sem
+				p.held--
+			}
+			slog.Info("Increasing upload concurrency", "concurrency", p.limit)
+		}
+	default:
+		return
+	}
+	p.requests, p.failures = 0, 0
+}
+
+// isOverloaded reports whether a failed request means S3 is throttling or
+// struggling, rather than rejecting the request itself
+func isOverloaded(err error) bool {
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		return true
+	}
+	var respErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &respErr) &&
+		(respErr.HTTPStatusCode() == http.StatusTooManyRequests || respErr.HTTPStatusCode() >= 500)
+}
+
+// requestObserverKey is the context key of the adaptive pool running a job
+type requestObserverKey struct{}
+
+// observeRequest reports the outcome of an S3 request to the adaptive pool whose
+// job made it, if any
+func observeRequest(ctx context.Context, err error) {
+	if p, ok := ctx.Value(requestObserverKey{}).(*uploadPool); ok {
+		p.observe(err)
+	}
+}
+
+// Wait blocks until every started job has finished and returns the first failure,
+// or the context error if the pool was canceled from outside.
+func (p *uploadPool) Wait() error {
+	p.wg.Wait()
+	defer p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return p.ctx.Err()
+}
@@ -0,0 +1,42 @@
+package syncd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestUploadPoolAdaptsConcurrency(t *testing.T) {
+	pool := newUploadPool(context.Background(), 8)
+	pool.adapt(0.1, 10)
+	ctx := context.WithValue(context.Background(), requestObserverKey{}, pool)
+	throttled := httpError(503, &smithy.GenericAPIError{Code: "SlowDown"})
+	denied := httpError(403, &smithy.GenericAPIError{Code: "AccessDenied"})
+
+	// Each burst of throttling halves the workers, down to 1
+	for _, want := range []int{4, 2, 1, 1} {
+		observeRequest(ctx, throttled)
+		observeRequest(ctx, throttled)
+		if pool.limit != want {
+			t.Fatalf("limit after throttling = %d, want %d", pool.limit, want)
+		}
+	}
+	if free := cap(pool.sem) - len(pool.sem); free != 1 {
+		t.Errorf("%d free worker slots, want 1", free)
+	}
+
+	// Client errors don't count; each window of successes adds a worker back
+	for _, want := range []int{2, 3} {
+		observeRequest(ctx, denied)
+		for range 10 {
+			observeRequest(ctx, nil)
+		}
+		if pool.limit != want {
+			t.Fatalf("limit after successes = %d, want %d", pool.limit, want)
+		}
+	}
+	if free := cap(pool.sem) - len(pool.sem); free != 3 {
+		t.Errorf("%d free worker slots, want 3", free)
+	}
+}
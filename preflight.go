@@ -0,0 +1,91 @@
+package syncd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Preflight checks that the credentials work and the bucket is reachable before
+// any sync work starts. Failures that won't fix themselves are returned as configError.
+func Preflight(ctx context.Context, awsConfig aws.Config, client S3API, cfg *SyncConfig) error {
+	if err := checkLocalDir(cfg); err != nil {
+		return err
+	}
+
+	// S3-compatible stores generally don't implement STS, so only HeadBucket applies there
+	if cfg.EndpointURL == "" {
+		identity, err := sts.NewFromConfig(awsConfig).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return preflightError(fmt.Errorf("credentials check (sts:GetCallerIdentity) failed, "+
+				"check aws_access_key/aws_secret_key, aws_profile or assume_role_arn: %w", err))
+		}
+		slog.Info("Authenticated", "arn", aws.ToString(identity.Arn))
+	}
+
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &cfg.BucketName})
+	if err != nil {
+		return preflightError(fmt.Errorf("bucket check (s3:HeadBucket) failed for %s, "+
+			"check bucket_name, region and the bucket policy: %w", cfg.BucketName, err))
+	}
+	slog.Info("Bucket is reachable", "bucket", cfg.BucketName)
+	return nil
+}
+
+// preflightError marks non-transient preflight failures as fatal, since HeadBucket
+// reports a missing or forbidden bucket only as a bare 404 or 403
+func preflightError(err error) error {
+	if isRetryable(err) {
+		return err
+	}
+	return &configError{err}
+}
+
+// checkLocalDir makes sure LocalDir is a readable directory and, unless allow_empty
+// is set, not empty: an empty tree usually means a mistyped path or an unmounted
+// volume, and syncing it could delete everything under the prefix. Downloads
+// create LocalDir as needed, so direction=down isn't checked.
+func checkLocalDir(cfg *SyncConfig) error {
+	if cfg.Direction == directionDown {
+		return nil
+	}
+
+	dir, err := os.Open(cfg.LocalDir)
+	if err != nil {
+		return &configError{fmt.Errorf("local_dir %s isn't readable: %v", cfg.LocalDir, err)}
+	}
+	defer dir.Close()
+	info, err := dir.Stat()
+	if err != nil {
+		return &configError{fmt.Errorf("local_dir %s isn't readable: %v", cfg.LocalDir, err)}
+	}
+	if !info.IsDir() {
+		return &configError{fmt.Errorf("local_dir %s is not a directory", cfg.LocalDir)}
+	}
+	if cfg.AllowEmpty {
+		return nil
+	}
+
+	// The instance lock doesn't count as content
+	for {
+		names, err := dir.Readdirnames(16)
+		for _, name := range names {
+			if name != LockFileName {
+				return nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return &configError{fmt.Errorf("local_dir %s is empty; is the volume mounted? Set allow_empty=true to sync it anyway", cfg.LocalDir)}
+		}
+		if err != nil {
+			return &configError{fmt.Errorf("local_dir %s isn't readable: %v", cfg.LocalDir, err)}
+		}
+	}
+}
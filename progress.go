@@ -0,0 +1,77 @@
+package syncd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn
+const progressInterval = 250 * time.Millisecond
+
+// progressSnapshot returns how many files the upload pass has finished and how many
+// bytes it has uploaded so far
+func (s *syncState) progressSnapshot() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.processed, s.uploadedBytes
+}
+
+// countProcessed records a file the upload pass is done with, whatever the outcome
+func (s *syncState) countProcessed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed++
+}
+
+// startProgress redraws a single progress line for the upload pass on w until the
+// returned function is called, which draws the final line and ends it. Workers only
+// update the counters in state, so one goroutine owns w.
+func startProgress(w io.Writer, state *syncState, total int) func() {
+	startedAt := time.Now()
+	draw := func() {
+		done, bytes := state.progressSnapshot()
+		rate := float64(bytes) / max(time.Since(startedAt).Seconds(), 0.001)
+		// \033[K clears whatever a longer previous line left behind
+		fmt.Fprintf(w, "\r%d/%d files, %s uploaded, %s/s\033[K", done, total, formatSize(bytes), formatSize(int64(rate)))
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				draw()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		wg.Wait()
+		draw()
+		fmt.Fprintln(w)
+	}
+}
+
+// formatSize renders a byte count with a binary unit, e.g. "1.5MiB"
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	value, exp := float64(bytes)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, "KMGTP"[exp])
+}
@@ -0,0 +1,49 @@
+package syncd
+
+import "reflect"
+
+// restartOnlyConfigFields lists SyncConfig fields a reload can't change: the S3 client,
+// lock, schedule mode and process-wide logging and servers are set up once at startup
+var restartOnlyConfigFields = map[string]bool{
+	"Name":            true,
+	"AWSAccessKey":    true,
+	"AWSSecretKey":    true,
+	"AWSProfile":      true,
+	"LocalDir":        true,
+	"BucketName":      true,
+	"Region":          true,
+	"Schedule":        true,
+	"EndpointURL":     true,
+	"UsePathStyle":    true,
+	"AllowedBuckets":  true,
+	"AssumeRoleARN":   true,
+	"ExternalID":      true,
+	"RoleSessionName": true,
+	"Watch":           true,
+	"WatchDebounce":   true,
+	"HTTPTimeout":     true,
+	"LogFormat":       true,
+	"LogLevel":        true,
+	"MetricsAddr":     true,
+	"HealthAddr":      true,
+}
+
+// RetainRestartOnly copies the fields of running that can't change without a restart
+// into reloaded, and returns the names of those that differed. SyncInterval may
+// change, but not to or from zero, which switches between periodic and one-time syncs.
+func RetainRestartOnly(running, reloaded *SyncConfig) []string {
+	var ignored []string
+	runningValue := reflect.ValueOf(running).Elem()
+	reloadedValue := reflect.ValueOf(reloaded).Elem()
+	for i := 0; i < runningValue.NumField(); i++ {
+		name := runningValue.Type().Field(i).Name
+		restartOnly := restartOnlyConfigFields[name] ||
+			(name == "SyncInterval" && (running.SyncInterval == 0) != (reloaded.SyncInterval == 0))
+		if !restartOnly || reflect.DeepEqual(runningValue.Field(i).Interface(), reloadedValue.Field(i).Interface()) {
+			continue
+		}
+		ignored = append(ignored, name)
+		reloadedValue.Field(i).Set(runningValue.Field(i))
+	}
+	return ignored
+}
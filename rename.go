@@ -0,0 +1,66 @@
+package syncd
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findRenames matches local files that have no object yet against objects whose
+// local file is gone, by size and then by MD5 against the listing's ETag. It returns
+// local relative path -> remote relative path of the object to copy from. Multipart
+// and SSE-KMS ETags aren't content MD5s, so those objects never match.
+func findRenames(cfg *SyncConfig, state *syncState) (map[string]string, error) {
+	renames := make(map[string]string)
+	removed, err := remoteOnly(cfg, state)
+	if err != nil {
+		return nil, err
+	}
+	bySize := make(map[int64][]string)
+	for _, relPath := range removed {
+		obj := state.remoteFiles[relPath]
+		if !isMultipartETag(obj.etag) {
+			bySize[obj.size] = append(bySize[obj.size], relPath)
+		}
+	}
+	if len(bySize) == 0 {
+		return renames, nil
+	}
+
+	localFiles, err := listFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for relPath := range localFiles {
+		if _, exists := state.remoteFiles[remoteRelPath(cfg, relPath)]; exists {
+			continue
+		}
+		localPath := filepath.Join(cfg.LocalDir, filepath.FromSlash(relPath))
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return nil, err
+		}
+		f := &localFile{path: localPath, relPath: relPath, info: info}
+		if err := loadContent(cfg, f); err != nil {
+			return nil, err
+		}
+		candidates := bySize[f.size()]
+		if len(candidates) == 0 {
+			continue
+		}
+
+		sum, err := localMD5(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, oldPath := range candidates {
+			if strings.Trim(state.remoteFiles[oldPath].etag, "\"") == sum {
+				slog.Debug("Detected renamed file", "path", relPath, "old_path", oldPath)
+				renames[relPath] = oldPath
+				break
+			}
+		}
+	}
+	return renames, nil
+}
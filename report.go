@@ -0,0 +1,94 @@
+package syncd
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// syncReport is the JSON summary written to report_path after each sync
+type syncReport struct {
+	Target        string          `json:"target,omitempty"`
+	StartedAt     time.Time       `json:"started_at"`
+	FinishedAt    time.Time       `json:"finished_at"`
+	DryRun        bool            `json:"dry_run"`
+	Uploaded      int             `json:"uploaded"`
+	Deleted       int             `json:"deleted"`
+	Skipped       int             `json:"skipped"`
+	BytesUploaded int64           `json:"bytes_uploaded"`
+	Failures      []reportFailure `json:"failures"` // files that failed with continue_on_error
+	Error         string          `json:"error,omitempty"`
+}
+
+type reportFailure struct {
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error"`
+}
+
+// writeReport replaces cfg.ReportPath with a summary of a sync. The file is written
+// to a temporary file and renamed, so readers never see a partial report.
+// Failures are logged but never returned so they can't fail the sync itself.
+func writeReport(cfg *SyncConfig, startedAt time.Time, result SyncResult, syncErr error) {
+	if cfg.ReportPath == "" {
+		return
+	}
+	if err := writeFileAtomic(cfg.ReportPath, newSyncReport(cfg, startedAt, result, syncErr)); err != nil {
+		slog.Error("Error writing sync report", "path", cfg.ReportPath, "err", err)
+	}
+}
+
+// newSyncReport summarizes a sync for report_path and log_to_s3_prefix
+func newSyncReport(cfg *SyncConfig, startedAt time.Time, result SyncResult, syncErr error) syncReport {
+	report := syncReport{
+		Target:        cfg.Name,
+		StartedAt:     startedAt.UTC(),
+		FinishedAt:    time.Now().UTC(),
+		DryRun:        cfg.DryRun,
+		Uploaded:      result.FilesUploaded,
+		Deleted:       result.FilesDeleted,
+		Skipped:       result.FilesSkipped,
+		BytesUploaded: result.BytesUploaded,
+		Failures:      []reportFailure{},
+	}
+	for _, err := range result.Errors {
+		var fileErr *fileError
+		if errors.As(err, &fileErr) {
+			report.Failures = append(report.Failures, reportFailure{Path: fileErr.path, Error: fileErr.err.Error()})
+		} else {
+			report.Failures = append(report.Failures, reportFailure{Error: err.Error()})
+		}
+	}
+	if syncErr != nil {
+		report.Error = syncErr.Error()
+	}
+	return report
+}
+
+// writeFileAtomic writes v as indented JSON to a temporary file next to path and
+// renames it into place
+func writeFileAtomic(path string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), TempFilePrefix+"report-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
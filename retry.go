@@ -0,0 +1,101 @@
+package syncd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Backoff bounds for retrying a single S3 request; delays double from
+// retryBaseDelay and are capped at retryMaxDelay before jitter is applied
+const (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 20 * time.Second
+)
+
+// retryableErrors covers connection errors, 5xx and throttling such as SlowDown,
+// using the SDK's own classification. 4xx errors like AccessDenied aren't retried.
+var (
+	retryableErrors = retry.IsErrorRetryables(retry.DefaultRetryables)
+	timeoutErrors   = retry.IsErrorTimeouts(retry.DefaultTimeouts)
+)
+
+// corruptionErrorCodes mean S3 received different bytes than were sent, so
+// sending them again is worth it
+var corruptionErrorCodes = map[string]bool{
+	"BadDigest":                 true,
+	"XAmzContentSHA256Mismatch": true,
+}
+
+// isRetryable reports whether a failed request is worth trying again
+func isRetryable(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && corruptionErrorCodes[apiErr.ErrorCode()] {
+		return true
+	}
+	if timeoutErrors.IsErrorTimeout(err) == aws.TrueTernary {
+		return true
+	}
+	var respErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == 429 {
+		return true
+	}
+	return retryableErrors.IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// retryAfter returns the delay requested by a Retry-After header, if any
+func retryAfter(err error) (time.Duration, bool) {
+	var respErr interface{ HTTPResponse() *smithyhttp.Response }
+	if !errors.As(err, &respErr) || respErr.HTTPResponse() == nil {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(respErr.HTTPResponse().Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// operationContext bounds a single S3 call by operation_timeout, so a stalled
+// connection fails (and is retried) instead of hanging the sync
+func operationContext(ctx context.Context, cfg *SyncConfig) (context.Context, context.CancelFunc) {
+	if cfg.OperationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, cfg.OperationTimeout)
+}
+
+// withRetry calls op until it succeeds, fails with a non-retryable error, or
+// maxRetries retries have been used, and returns op's last error.
+// Delays use exponential backoff with full jitter unless S3 sends Retry-After.
+func withRetry(ctx context.Context, maxRetries int, what string, op func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		observeRequest(ctx, err)
+		if err == nil || attempt >= maxRetries || !isRetryable(err) {
+			return err
+		}
+
+		wait, hinted := retryAfter(err)
+		if !hinted {
+			wait = rand.N(delay) + 1
+		}
+		slog.Warn("Retrying "+what, "wait", wait.Round(time.Millisecond), "retry", attempt+1, "max_retries", maxRetries, "err", err)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
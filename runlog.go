@@ -0,0 +1,123 @@
+package syncd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runLogWriter tees log output into an in-memory buffer while a sync run
+// is being captured so it can be shipped to S3 afterwards.
+type runLogWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+	buf *bytes.Buffer
+}
+
+// RunLog must be the output of the default logger (see NewLogger) for
+// log_to_s3_prefix to capture run logs.
+// It writes through to stderr.
+var RunLog = &runLogWriter{out: os.Stderr}
+
+func (w *runLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf != nil {
+		w.buf.Write(p)
+	}
+	return w.out.Write(p)
+}
+
+// startCapture begins buffering log output for the current run
+func (w *runLogWriter) startCapture() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = &bytes.Buffer{}
+}
+
+// stopCapture stops buffering and returns everything captured since startCapture
+func (w *runLogWriter) stopCapture() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf == nil {
+		return nil
+	}
+	captured := w.buf.Bytes()
+	w.buf = nil
+	return captured
+}
+
+// uploadRunLog writes a captured run log to log_to_s3_prefix as <timestamp>.log, with
+// the run's summary (as in report_path) next to it as <timestamp>.json, and prunes
+// old runs. Failures are logged but never returned so they can't fail the sync itself.
+func uploadRunLog(ctx context.Context, backend Backend, cfg *SyncConfig, startedAt time.Time, content []byte, result SyncResult, syncErr error) {
+	runKey := objectKey(cfg.LogToS3Prefix, startedAt.UTC().Format("20060102T150405Z"))
+
+	summary, err := json.MarshalIndent(newSyncReport(cfg, startedAt, result, syncErr), "", "  ")
+	if err != nil {
+		slog.Error("Error encoding run summary", "err", err)
+		return
+	}
+	for _, object := range []struct {
+		key  string
+		body []byte
+	}{
+		{runKey + ".log", content},
+		{runKey + ".json", append(summary, '\n')},
+	} {
+		if err := backend.Put(ctx, object.key, object.body); err != nil {
+			slog.Error("Error uploading run log", "key", object.key, "err", err)
+			return
+		}
+		slog.Info("Uploaded run log", "key", object.key)
+	}
+
+	if cfg.LogS3Keep > 0 {
+		pruneRunLogs(ctx, backend, cfg)
+	}
+}
+
+// pruneRunLogs deletes the oldest runs' .log and .json files so at most LogS3Keep
+// runs remain. Keys are timestamp-named so lexical order is chronological order.
+func pruneRunLogs(ctx context.Context, backend Backend, cfg *SyncConfig) {
+	objects, err := backend.List(ctx, dirPrefix(cfg.LogToS3Prefix))
+	if err != nil {
+		slog.Error("Error listing run logs for pruning", "err", err)
+		return
+	}
+
+	// Keys of each run's files, by the run's key without extension
+	runs := make(map[string][]string)
+	for _, obj := range objects {
+		if ext := path.Ext(obj.Key); ext == ".log" || ext == ".json" {
+			run := strings.TrimSuffix(obj.Key, ext)
+			runs[run] = append(runs[run], obj.Key)
+		}
+	}
+
+	if len(runs) <= cfg.LogS3Keep {
+		return
+	}
+
+	runKeys := slices.Sorted(maps.Keys(runs))
+	for _, run := range runKeys[:len(runKeys)-cfg.LogS3Keep] {
+		for _, key := range runs[run] {
+			if err := backend.Delete(ctx, key); err != nil {
+				slog.Error("Error pruning run log", "key", key, "err", err)
+				continue
+			}
+			slog.Debug("Pruned old run log", "key", key)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package syncd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUploadRunLog(t *testing.T) {
+	client := newFakeS3()
+	// Two earlier runs, the oldest without a summary, and an unrelated object
+	client.put("logs/20240101T000000Z.log", []byte("old"), nil)
+	client.put("logs/20240102T000000Z.log", []byte("older"), nil)
+	client.put("logs/20240102T000000Z.json", []byte("{}"), nil)
+	client.put("logs/notes.txt", []byte("keep"), nil)
+	cfg := testConfig(t, t.TempDir(), map[string]string{"log_to_s3_prefix": "logs/", "log_s3_keep": "2"})
+
+	startedAt := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	result := SyncResult{FilesUploaded: 3, FilesSkipped: 1, BytesUploaded: 42}
+	uploadRunLog(context.Background(), NewS3Backend(client, cfg), cfg, startedAt, []byte("log line\n"), result, errors.New("sync failed"))
+
+	want := []string{
+		"logs/20240102T000000Z.json",
+		"logs/20240102T000000Z.log",
+		"logs/20240103T000000Z.json",
+		"logs/20240103T000000Z.log",
+		"logs/notes.txt",
+	}
+	if got := client.keys(); !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	if got := string(client.object("logs/20240103T000000Z.log").body); got != "log line\n" {
+		t.Errorf("run log = %q, want the captured log", got)
+	}
+	var report syncReport
+	if err := json.Unmarshal(client.object("logs/20240103T000000Z.json").body, &report); err != nil {
+		t.Fatalf("summary isn't JSON: %v", err)
+	}
+	if report.Uploaded != 3 || report.Skipped != 1 || report.BytesUploaded != 42 || report.Error != "sync failed" {
+		t.Errorf("summary = %+v, want the run's result and error", report)
+	}
+}
@@ -0,0 +1,24 @@
+package syncd
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3API is the subset of the S3 client that syncd uses. *s3.Client satisfies it;
+// tests can substitute an in-memory fake.
+type S3API interface {
+	manager.UploadAPIClient // PutObject and the multipart upload calls
+	s3.ListObjectsV2APIClient
+	s3.HeadObjectAPIClient
+	s3.HeadBucketAPIClient
+	s3.ListMultipartUploadsAPIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+var _ S3API = (*s3.Client)(nil)
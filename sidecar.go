@@ -0,0 +1,48 @@
+package syncd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sidecarSuffix names a JSON file of upload settings for the file it sits next to,
+// e.g. report.pdf.syncdmeta for report.pdf. Sidecars themselves are never synced.
+const sidecarSuffix = ".syncdmeta"
+
+// objectMeta is the content of a sidecar file
+type objectMeta struct {
+	ContentType  string            `json:"ContentType"`
+	CacheControl string            `json:"CacheControl"`
+	Metadata     map[string]string `json:"Metadata"` // user metadata; keys are lowercased like S3 does
+}
+
+// loadSidecar reads f's sidecar file, if it has one, and caches it on f
+func loadSidecar(f *localFile) error {
+	if f.sidecarLoaded {
+		return nil
+	}
+	f.sidecarLoaded = true
+
+	sidecarPath := f.path + sidecarSuffix
+	content, err := os.ReadFile(sidecarPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var meta objectMeta
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return fmt.Errorf("invalid sidecar %s: %v", sidecarPath, err)
+	}
+	metadata := make(map[string]string, len(meta.Metadata))
+	for key, value := range meta.Metadata {
+		metadata[strings.ToLower(key)] = value
+	}
+	meta.Metadata = metadata
+	f.meta = &meta
+	return nil
+}
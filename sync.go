@@ -0,0 +1,2370 @@
+package syncd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/time/rate"
+)
+
+// SyncConfig is a parsed config file; see ReadConfigFile
+type SyncConfig struct {
+	Name             string // [target] section name; empty for single-target configs
+	AWSAccessKey     string
+	AWSSecretKey     string
+	AWSProfile       string // used when no static keys are set; empty uses the default chain
+	LocalDir         string
+	BucketName       string
+	Prefix           string
+	Region           string // empty falls back to AWS_REGION / shared config
+	SyncInterval     time.Duration
+	SyncJitter       time.Duration
+	Schedule         string // cron expression, replaces SyncInterval
+	SyncMarkerFile   string
+	WriteMarkers     bool
+	EndpointURL      string // S3-compatible endpoint (MinIO, Ceph, R2); empty uses AWS
+	UsePathStyle     bool
+	LogToS3Prefix    string
+	LogS3Keep        int
+	Compare          string
+	Overwrite        string // replaces Compare for existing objects when set
+	MtimeTolerance   time.Duration
+	AllowedBuckets   []string
+	UploadOrder      string
+	UploadOrderChunk int
+	MaxDepth         int
+	Exclude          []*globPattern // matched paths are never synced; wins over Include
+	Include          []*globPattern // when set, only matching files are synced
+	Keep             []*globPattern // remote keys that are never deleted
+	RespectGitignore bool
+	ContentLanguage  string
+	WebsiteRedirects map[string]string
+	ContentTypes     map[string]string // extension (with dot) -> Content-Type override
+	Tags             url.Values        // object tags set on uploaded files
+	SyncRetries      int
+	SyncRetryBackoff time.Duration
+	MaxRetries       int // per-request retries for transient S3 errors
+	NoDeletePrefixes []string
+	OnSpecialFile    string
+	// Symlinks is skip, follow or error; see the symlinks* constants
+	Symlinks string
+	// Symlinks resolving outside LocalDir and SymlinkAllowedRoots are skipped or fail the sync
+	OnEscapingSymlink   string
+	SymlinkAllowedRoots []string
+	ChecksumIndex       string
+	SuccessMarker       bool
+	ContinueOnError     bool
+	ManifestMode        bool
+	Concurrency         int // files uploaded in parallel
+	// adaptive_concurrency: shrink the upload pool while S3 throttles or fails
+	AdaptiveConcurrency bool
+	AdaptiveErrorRate   float64 // share of requests in a window that shrinks the pool
+	AdaptiveWindow      int     // requests the error rate is measured over
+	MarkerConcurrency   int
+	DryRun              bool
+	PrioritizeFailed    bool
+	NormalizeText       map[string]bool // lowercase extensions (without dot) to normalize
+	GzipExtensions      map[string]bool // lowercase extensions (without dot) to gzip
+
+	// Cross-account role assumed with the base credentials before touching the bucket
+	AssumeRoleARN   string
+	ExternalID      string
+	RoleSessionName string
+	STSMaxRetries   int           // retries of a failed AssumeRole call
+	STSTimeout      time.Duration // bound on each AssumeRole call, retries included
+
+	// Optional regex rewrite of relative paths into keys, e.g. ^data/(.+)\.raw$ -> archive/$1.raw
+	KeyRewrite            *regexp.Regexp
+	KeyRewriteReplacement string
+
+	// Sync on filesystem changes instead of every SyncInterval, once events
+	// have been quiet for WatchDebounce
+	Watch         bool
+	WatchDebounce time.Duration
+
+	// Which way files flow, and which side wins a conflict when Direction is "both"
+	Direction string
+	Conflict  string
+
+	// Sync even when LocalDir is empty, which otherwise suggests an unmounted volume
+	AllowEmpty bool
+
+	// Delete objects under the prefix that no longer exist locally, first copying
+	// them under TrashPrefix when it is set
+	DeleteRemoved bool
+	TrashPrefix   string
+
+	// Most objects a single delete may remove, as a count or a percentage of the
+	// remote objects; negative values mean no limit
+	MaxDelete        int
+	MaxDeletePercent float64
+
+	// Files larger than MaxFileSize (when set) or smaller than MinFileSize are skipped
+	MaxFileSize int64
+	MinFileSize int64
+
+	// Aggregate upload rate in bytes per second across all workers; 0 is unlimited
+	MaxBandwidth int64
+
+	// Checksum S3 verifies each upload against: md5, sha256, sha1, crc32 or crc32c
+	Checksum string
+
+	// Cache-Control for uploads, overridden by extension (with dot), and the Expires
+	// header as a fixed time or as ExpiresAfter past each upload
+	CacheControl      string
+	CacheControlByExt map[string]string
+	ExpiresAt         time.Time
+	ExpiresAfter      time.Duration
+
+	// Bounds on a single HTTP request (including its body) and on each S3 call
+	// with its retries excluded; zero means no limit
+	HTTPTimeout      time.Duration
+	OperationTimeout time.Duration
+
+	// After FailureThreshold consecutive failed syncs the daemon backs off, skipping
+	// syncs for exponentially longer up to MaxFailureBackoff; zero disables it
+	FailureThreshold  int
+	MaxFailureBackoff time.Duration
+
+	// Re-checks, VerifyDelay apart, of a file missing from the verification listing,
+	// for S3-compatible stores where new objects show up in listings late
+	VerifyRetries int
+	VerifyDelay   time.Duration
+
+	// Files of at least MultipartThreshold bytes are uploaded in PartSize-byte parts.
+	// Incomplete multipart uploads older than AbortStaleMultiparts are aborted at
+	// startup; zero leaves them alone.
+	MultipartThreshold   int64
+	PartSize             int64
+	AbortStaleMultiparts time.Duration
+
+	// Files with the same content as one uploaded earlier in the run are copied
+	// from it server-side instead of being uploaded again
+	Dedupe bool
+	// New files matching an object whose local file is gone are copied from it
+	// server-side; delete_removed then removes the old key
+	DetectRenames bool
+
+	// Rates used by the plan command's cost estimate, in USD
+	CostPer1kPut  float64
+	CostPer1kList float64
+	CostPer1kHead float64
+	CostPerGB     float64 // storage per GB-month
+
+	// File replaced with a JSON summary after every sync; empty disables it
+	ReportPath string
+
+	// Log output format ("text" or "json") and the lowest level that is logged
+	LogFormat string
+	LogLevel  slog.Level
+
+	// Listen addresses for the Prometheus /metrics endpoint and the /healthz and
+	// /readyz probes; empty disables them
+	MetricsAddr string
+	HealthAddr  string
+
+	// Storage classes for uploaded files and for marker/_SUCCESS objects
+	StorageClass       string
+	MarkerStorageClass string
+
+	// SSE-S3 ("AES256") or SSE-KMS ("aws:kms") encryption for uploads and markers
+	SSE         string
+	SSEKMSKeyID string
+
+	// SSE-C settings; the key is base64-encoded and never stored by AWS
+	SSECustomerAlgorithm string
+	SSECustomerKey       string
+	SSECustomerKeyMD5    string
+}
+
+// roleARNPattern matches IAM role ARNs in any partition, e.g. arn:aws:iam::123456789012:role/syncd
+var roleARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/.+$`)
+
+// LockFileName is the file in LocalDir that a running instance holds locked.
+// It is never synced.
+const LockFileName = ".syncd.lock"
+
+// TempFilePrefix starts the names of the temporary files syncd writes next to a
+// file before renaming them into place, e.g. during downloads
+const TempFilePrefix = ".syncd-"
+
+// successMarkerName is the Hadoop-style completion object written at the prefix root
+const successMarkerName = "_SUCCESS"
+
+// Upload orders applied to files before they are uploaded
+const (
+	orderPath      = "path"       // walk order, files are uploaded as they are found
+	orderMtimeDesc = "mtime_desc" // most recently modified first
+	orderSizeAsc   = "size_asc"   // smallest first
+	orderSizeDesc  = "size_desc"  // largest first
+)
+
+// Compare modes used to decide whether a local file needs uploading
+const (
+	compareExists   = "exists"   // upload only when the key is missing remotely
+	compareSize     = "size"     // also upload when the remote size differs
+	compareMtime    = "mtime"    // also upload when the stored mtime metadata differs
+	compareChecksum = "checksum" // also upload when the stored SHA-256 metadata differs
+	compareETag     = "etag"     // also upload when the content MD5 differs from the ETag
+)
+
+// Overwrite policies for objects that already exist, overriding the compare mode
+const (
+	overwriteNever   = "never"    // never replace an existing object
+	overwriteAlways  = "always"   // re-upload every file
+	overwriteIfNewer = "if-newer" // re-upload when the local mtime is after the object's LastModified
+)
+
+// Log output formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logLevels maps log_level values to slog levels
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// mtimeMetadataKey is the user metadata entry (x-amz-meta-mtime) holding the source file's mtime
+const mtimeMetadataKey = "mtime"
+
+// ReadConfigFile parses and validates a key=value config file with a single target
+func ReadConfigFile(path string) (*SyncConfig, error) {
+	targets, err := ReadConfigTargets(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) > 1 {
+		return nil, fmt.Errorf("config file defines %d targets, expected one", len(targets))
+	}
+	return targets[0], nil
+}
+
+// ReadConfigTargets parses and validates a key=value config file into one
+// SyncConfig per [target] section. Keys above the first section are shared by
+// every target and can be overridden inside a section. A file without sections
+// is a single target. Files ending in .yaml or .yml are read as YAML instead.
+// SYNCD_* environment variables (see envOverrides) win over the file.
+func ReadConfigTargets(path string) ([]*SyncConfig, error) {
+	readSections := readConfigSections
+	if isYAMLConfig(path) {
+		readSections = readYAMLConfigSections
+	}
+	shared, sections, err := readSections(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(sections) == 0 {
+		applyEnvOverrides(shared)
+		config, err := parseConfig(shared)
+		if err != nil {
+			return nil, err
+		}
+		return []*SyncConfig{config}, nil
+	}
+
+	targets := make([]*SyncConfig, 0, len(sections))
+	seen := make(map[string]string)    // local_dir -> target name
+	reports := make(map[string]string) // report_path -> target name
+	for _, section := range sections {
+		configMap := maps.Clone(shared)
+		maps.Copy(configMap, section.values)
+		applyEnvOverrides(configMap)
+
+		config, err := parseConfig(configMap)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", section.name, err)
+		}
+
+		// Targets sync concurrently in one process, so they can't share a
+		// local_dir lock or the captured run log
+		if config.LogToS3Prefix != "" && len(sections) > 1 {
+			return nil, fmt.Errorf("target %s: log_to_s3_prefix isn't supported with multiple targets", section.name)
+		}
+		if other, exists := seen[config.LocalDir]; exists {
+			return nil, fmt.Errorf("targets %s and %s both sync local_dir %s", other, section.name, config.LocalDir)
+		}
+		seen[config.LocalDir] = section.name
+		if other, exists := reports[config.ReportPath]; exists && config.ReportPath != "" {
+			return nil, fmt.Errorf("targets %s and %s both write report_path %s", other, section.name, config.ReportPath)
+		}
+		reports[config.ReportPath] = section.name
+		config.Name = section.name
+		targets = append(targets, config)
+	}
+	return targets, nil
+}
+
+// configSection is the keys of one [target] section
+type configSection struct {
+	name   string
+	values map[string]string
+}
+
+// readConfigSections reads a config file into its shared keys and its [target]
+// sections. Sections may be named ([target backups]); unnamed ones are numbered.
+// A path of "-" reads from stdin, so secrets never have to touch the disk.
+func readConfigSections(path string) (map[string]string, []configSection, error) {
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening config file: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	scanner := bufio.NewScanner(input)
+	shared := make(map[string]string)
+	var sections []configSection
+	configMap := shared
+	names := make(map[string]bool)
+
+	// Read config file line by line
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue // Skip empty lines and comments
+		}
+
+		// A [target] header starts a new section
+		if header, isSection := strings.CutPrefix(line, "["); isSection {
+			header, closed := strings.CutSuffix(header, "]")
+			kind, name, _ := strings.Cut(strings.TrimSpace(header), " ")
+			if !closed || kind != "target" {
+				return nil, nil, fmt.Errorf("invalid config section: %s (expected [target] or [target <name>])", line)
+			}
+			name = strings.TrimSpace(name)
+			if name == "" {
+				name = strconv.Itoa(len(sections) + 1)
+			}
+			if names[name] {
+				return nil, nil, fmt.Errorf("duplicate target: %s", name)
+			}
+			names[name] = true
+			sections = append(sections, configSection{name: name, values: make(map[string]string)})
+			configMap = sections[len(sections)-1].values
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("invalid config line: %s", line)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value, err := parseConfigValue(parts[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid config line: %s (%v)", line, err)
+		}
+		configMap[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	return shared, sections, nil
+}
+
+// parseConfigValue trims a raw value and strips a trailing comment starting with
+// whitespace and #. A double-quoted value is taken verbatim, including whitespace,
+// = and #, with \" and \\ escaping a quote and a backslash.
+func parseConfigValue(raw string) (string, error) {
+	quoted, isQuoted := strings.CutPrefix(strings.TrimSpace(raw), `"`)
+	if !isQuoted {
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '#' && (raw[i-1] == ' ' || raw[i-1] == '\t') {
+				return strings.TrimSpace(raw[:i]), nil
+			}
+		}
+		return strings.TrimSpace(raw), nil
+	}
+
+	var value strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		switch c := quoted[i]; {
+		case c == '\\' && i+1 < len(quoted) && (quoted[i+1] == '"' || quoted[i+1] == '\\'):
+			i++
+			value.WriteByte(quoted[i])
+		case c == '"':
+			rest := quoted[i+1:]
+			comment := strings.TrimLeft(rest, " \t")
+			if rest != "" && (comment == rest || !strings.HasPrefix(comment, "#")) {
+				return "", fmt.Errorf("unexpected text after closing quote")
+			}
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted value")
+}
+
+// parseConfig applies defaults and validates the keys of one target
+func parseConfig(configMap map[string]string) (*SyncConfig, error) {
+	var err error
+	config := &SyncConfig{
+		// Set default sync marker filename
+		SyncMarkerFile: "syncd.txt",
+		WriteMarkers:   true,
+		// Keep the 30 most recent run logs when log_to_s3_prefix is set
+		LogS3Keep: 30,
+		Compare:   compareExists,
+		Direction: directionUp,
+		Conflict:  conflictNewer,
+		// Coalesce bursts of writes into one sync
+		WatchDebounce: 2 * time.Second,
+		// Human-readable logs at info level and above
+		LogFormat: logFormatText,
+		LogLevel:  slog.LevelInfo,
+		// Absorb small clock differences between hosts in mtime comparisons
+		MtimeTolerance: time.Second,
+		UploadOrder:    orderPath,
+		// Sort at most this many files at a time to bound memory on huge trees
+		UploadOrderChunk: 10000,
+		// Negative depth means the whole tree is synced
+		MaxDepth: -1,
+		// No delete limit unless max_delete is set
+		MaxDelete:        -1,
+		MaxDeletePercent: -1,
+		// Initial delay between whole-sync retries, doubled after each attempt
+		SyncRetryBackoff:  30 * time.Second,
+		MaxRetries:        3,
+		STSMaxRetries:     3,
+		OnSpecialFile:     "skip",
+		Symlinks:          symlinksSkip,
+		OnEscapingSymlink: "skip",
+		Concurrency:       8,
+		MarkerConcurrency: 8,
+		AdaptiveErrorRate: 0.1,
+		AdaptiveWindow:    20,
+		// Back off from a degraded endpoint for at most an hour at a time
+		MaxFailureBackoff: time.Hour,
+		// A few seconds for lagging listings before a file counts as missing
+		VerifyRetries: 3,
+		VerifyDelay:   time.Second,
+		// Single PUTs are capped at 5GB; switch to multipart well before that
+		MultipartThreshold: 100 << 20,
+		PartSize:           manager.DefaultUploadPartSize,
+		// S3 Standard list prices in us-east-1
+		CostPer1kPut:  0.005,
+		CostPer1kList: 0.005,
+		CostPer1kHead: 0.0004,
+		CostPerGB:     0.023,
+	}
+
+	// Validate and populate config
+	requiredFields := []string{"local_dir", "bucket_name"}
+	for _, field := range requiredFields {
+		if _, exists := configMap[field]; !exists {
+			return nil, fmt.Errorf("missing required config field: %s", field)
+		}
+	}
+
+	// Optional: static keys, otherwise a shared profile or the default credential chain
+	config.AWSAccessKey = configMap["aws_access_key"]
+	config.AWSSecretKey = configMap["aws_secret_key"]
+	if (config.AWSAccessKey == "") != (config.AWSSecretKey == "") {
+		return nil, fmt.Errorf("aws_access_key and aws_secret_key must be set together")
+	}
+	config.AWSProfile = configMap["aws_profile"]
+	if config.AWSProfile != "" && config.AWSAccessKey != "" {
+		return nil, fmt.Errorf("aws_profile can't be combined with aws_access_key/aws_secret_key")
+	}
+	// Paths may use ~ and $VARS; local_dir is made absolute so the working directory doesn't matter
+	config.LocalDir, err = resolveLocalDir(configMap["local_dir"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid local_dir: %v", err)
+	}
+	config.BucketName = configMap["bucket_name"]
+	config.Prefix = normalizePrefix(os.ExpandEnv(configMap["prefix"]))
+	config.Region = configMap["region"] // Optional
+
+	// Optional: assume a role on top of the base credentials
+	if roleARN, exists := configMap["assume_role_arn"]; exists {
+		if !roleARNPattern.MatchString(roleARN) {
+			return nil, fmt.Errorf("invalid assume_role_arn: %s", roleARN)
+		}
+		config.AssumeRoleARN = roleARN
+		config.ExternalID = configMap["external_id"]
+		config.RoleSessionName = configMap["role_session_name"]
+		if config.RoleSessionName == "" {
+			config.RoleSessionName = "syncd"
+		}
+	} else if _, exists := configMap["external_id"]; exists {
+		return nil, fmt.Errorf("external_id requires assume_role_arn")
+	} else if _, exists := configMap["role_session_name"]; exists {
+		return nil, fmt.Errorf("role_session_name requires assume_role_arn")
+	}
+
+	// Optional: retries and timeout for the AssumeRole call, separate from S3's
+	if retriesStr, exists := configMap["sts_max_retries"]; exists {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid sts_max_retries: %s", retriesStr)
+		}
+		config.STSMaxRetries = retries
+	}
+	if timeoutStr, exists := configMap["sts_timeout"]; exists {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid sts_timeout: %s", timeoutStr)
+		}
+		config.STSTimeout = timeout
+	}
+
+	// Optional: rewrite relative paths into keys with a regex
+	if pattern, exists := configMap["key_rewrite"]; exists {
+		rewrite, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key_rewrite: %v", err)
+		}
+		config.KeyRewrite = rewrite
+		config.KeyRewriteReplacement = configMap["key_rewrite_replacement"]
+	} else if _, exists := configMap["key_rewrite_replacement"]; exists {
+		return nil, fmt.Errorf("key_rewrite_replacement requires key_rewrite")
+	}
+
+	// Optional: S3-compatible endpoint
+	if endpoint, exists := configMap["endpoint_url"]; exists {
+		parsed, err := url.Parse(endpoint)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint_url: %s (expected http(s)://host[:port])", endpoint)
+		}
+		config.EndpointURL = endpoint
+	}
+	if pathStyleStr, exists := configMap["use_path_style"]; exists {
+		pathStyle, err := strconv.ParseBool(pathStyleStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid use_path_style: %s", pathStyleStr)
+		}
+		config.UsePathStyle = pathStyle
+	}
+
+	// Optional: custom sync marker filename
+	if markerFile, exists := configMap["sync_marker_file"]; exists {
+		config.SyncMarkerFile = os.ExpandEnv(markerFile)
+	}
+
+	// Optional: skip writing sync marker files. Existing markers are still
+	// left out of uploads and deletes.
+	if writeStr, exists := configMap["write_markers"]; exists {
+		writeMarkers, err := strconv.ParseBool(writeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid write_markers: %s", writeStr)
+		}
+		config.WriteMarkers = writeMarkers
+	}
+
+	// Parse sync interval
+	if intervalStr, exists := configMap["sync_interval"]; exists {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync interval: %v", err)
+		}
+		config.SyncInterval = interval
+	}
+	if jitterStr, exists := configMap["sync_jitter"]; exists {
+		jitter, err := time.ParseDuration(jitterStr)
+		if err != nil || jitter < 0 {
+			return nil, fmt.Errorf("invalid sync_jitter: %s", jitterStr)
+		}
+		config.SyncJitter = jitter
+	}
+
+	// Optional: cron schedule, e.g. "0 2,14 * * *" for 2am and 2pm
+	if schedule, exists := configMap["schedule"]; exists {
+		if _, err := cron.ParseStandard(schedule); err != nil {
+			return nil, fmt.Errorf("invalid schedule: %v", err)
+		}
+		if config.SyncInterval > 0 {
+			return nil, fmt.Errorf("schedule can't be combined with sync_interval")
+		}
+		config.Schedule = schedule
+	}
+
+	// Optional: sync on filesystem changes
+	if watchStr, exists := configMap["watch"]; exists {
+		watch, err := strconv.ParseBool(watchStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch: %s", watchStr)
+		}
+		if watch && (config.SyncInterval > 0 || config.Schedule != "") {
+			return nil, fmt.Errorf("watch can't be combined with sync_interval or schedule")
+		}
+		config.Watch = watch
+	}
+	if debounceStr, exists := configMap["watch_debounce"]; exists {
+		debounce, err := time.ParseDuration(debounceStr)
+		if err != nil || debounce <= 0 {
+			return nil, fmt.Errorf("invalid watch_debounce: %s", debounceStr)
+		}
+		config.WatchDebounce = debounce
+	}
+
+	// Optional: retry a failed S3 request with backoff
+	if retriesStr, exists := configMap["max_retries"]; exists {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid max_retries: %s", retriesStr)
+		}
+		config.MaxRetries = retries
+	}
+	// Optional: retry a failed sync before waiting for the next interval
+	if retriesStr, exists := configMap["sync_retries"]; exists {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid sync_retries: %s", retriesStr)
+		}
+		config.SyncRetries = retries
+	}
+	if backoffStr, exists := configMap["sync_retry_backoff"]; exists {
+		backoff, err := time.ParseDuration(backoffStr)
+		if err != nil || backoff <= 0 {
+			return nil, fmt.Errorf("invalid sync_retry_backoff: %s", backoffStr)
+		}
+		config.SyncRetryBackoff = backoff
+	}
+	if thresholdStr, exists := configMap["failure_threshold"]; exists {
+		threshold, err := strconv.Atoi(thresholdStr)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid failure_threshold: %s", thresholdStr)
+		}
+		config.FailureThreshold = threshold
+	}
+	if backoffStr, exists := configMap["max_failure_backoff"]; exists {
+		backoff, err := time.ParseDuration(backoffStr)
+		if err != nil || backoff <= 0 {
+			return nil, fmt.Errorf("invalid max_failure_backoff: %s", backoffStr)
+		}
+		config.MaxFailureBackoff = backoff
+	}
+	if retriesStr, exists := configMap["verify_retries"]; exists {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid verify_retries: %s", retriesStr)
+		}
+		config.VerifyRetries = retries
+	}
+	if delayStr, exists := configMap["verify_delay"]; exists {
+		delay, err := time.ParseDuration(delayStr)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("invalid verify_delay: %s", delayStr)
+		}
+		config.VerifyDelay = delay
+	}
+
+	// Optional: log planned changes without touching the bucket
+	if dryRunStr, exists := configMap["dry_run"]; exists {
+		dryRun, err := strconv.ParseBool(dryRunStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dry_run: %s", dryRunStr)
+		}
+		config.DryRun = dryRun
+	}
+
+	// Optional: storage classes, validated against the SDK's known values
+	for key, field := range map[string]*string{
+		"storage_class":        &config.StorageClass,
+		"marker_storage_class": &config.MarkerStorageClass,
+	} {
+		if class, exists := configMap[key]; exists {
+			if !slices.Contains(types.StorageClass("").Values(), types.StorageClass(class)) {
+				return nil, fmt.Errorf("invalid %s: %s", key, class)
+			}
+			*field = class
+		}
+	}
+	// Markers stay in STANDARD so consumers can poll them cheaply, even when files
+	// go to an archive class
+	if _, exists := configMap["marker_storage_class"]; !exists {
+		config.MarkerStorageClass = string(types.StorageClassStandard)
+	}
+
+	// Optional: server-side encryption at rest
+	if sse, exists := configMap["sse"]; exists {
+		if sse != string(types.ServerSideEncryptionAes256) && sse != string(types.ServerSideEncryptionAwsKms) {
+			return nil, fmt.Errorf("invalid sse: %s (expected AES256 or aws:kms)", sse)
+		}
+		config.SSE = sse
+	}
+	if keyID, exists := configMap["sse_kms_key_id"]; exists {
+		if config.SSE != string(types.ServerSideEncryptionAwsKms) {
+			return nil, fmt.Errorf("sse_kms_key_id requires sse=aws:kms")
+		}
+		config.SSEKMSKeyID = keyID
+	}
+
+	// Optional: encrypt uploads with a customer-provided key (SSE-C)
+	if customerKey, exists := configMap["sse_customer_key"]; exists {
+		rawKey, err := base64.StdEncoding.DecodeString(customerKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sse_customer_key: must be base64-encoded: %v", err)
+		}
+		if len(rawKey) != 32 {
+			return nil, fmt.Errorf("invalid sse_customer_key: must decode to 32 bytes, got %d", len(rawKey))
+		}
+		keyMD5 := md5.Sum(rawKey)
+		if config.SSE != "" {
+			return nil, fmt.Errorf("sse_customer_key cannot be combined with sse")
+		}
+		config.SSECustomerAlgorithm = "AES256"
+		config.SSECustomerKey = customerKey
+		config.SSECustomerKeyMD5 = base64.StdEncoding.EncodeToString(keyMD5[:])
+	}
+
+	// Optional: retry subdirectories that failed last run before the full walk
+	if prioritizeStr, exists := configMap["prioritize_failed"]; exists {
+		prioritize, err := strconv.ParseBool(prioritizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prioritize_failed: %s", prioritizeStr)
+		}
+		config.PrioritizeFailed = prioritize
+	}
+
+	// Optional: how many files to upload in parallel
+	if concurrencyStr, exists := configMap["concurrency"]; exists {
+		concurrency, err := strconv.Atoi(concurrencyStr)
+		if err != nil || concurrency < 1 {
+			return nil, fmt.Errorf("invalid concurrency: %s", concurrencyStr)
+		}
+		config.Concurrency = concurrency
+	}
+
+	// Optional: shrink the upload pool while S3 throttles or returns 5xx errors
+	if adaptiveStr, exists := configMap["adaptive_concurrency"]; exists {
+		adaptive, err := strconv.ParseBool(adaptiveStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid adaptive_concurrency: %s", adaptiveStr)
+		}
+		config.AdaptiveConcurrency = adaptive
+	}
+	if rateStr, exists := configMap["adaptive_error_rate"]; exists {
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate < 0 || rate >= 1 {
+			return nil, fmt.Errorf("invalid adaptive_error_rate: %s", rateStr)
+		}
+		config.AdaptiveErrorRate = rate
+	}
+	if windowStr, exists := configMap["adaptive_window"]; exists {
+		window, err := strconv.Atoi(windowStr)
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("invalid adaptive_window: %s", windowStr)
+		}
+		config.AdaptiveWindow = window
+	}
+
+	// Optional: how many marker files to write in parallel
+	if concurrencyStr, exists := configMap["marker_concurrency"]; exists {
+		concurrency, err := strconv.Atoi(concurrencyStr)
+		if err != nil || concurrency < 1 {
+			return nil, fmt.Errorf("invalid marker_concurrency: %s", concurrencyStr)
+		}
+		config.MarkerConcurrency = concurrency
+	}
+
+	// Optional: checksum S3 verifies on upload
+	if checksum, exists := configMap["checksum"]; exists {
+		if _, supported := uploadChecksums[checksum]; !supported && checksum != checksumMD5 {
+			return nil, fmt.Errorf("invalid checksum: %s", checksum)
+		}
+		config.Checksum = checksum
+	}
+
+	// Optional: bounds on each HTTP request and each S3 call
+	if timeoutStr, exists := configMap["http_timeout"]; exists {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid http_timeout: %s", timeoutStr)
+		}
+		config.HTTPTimeout = timeout
+	}
+	if timeoutStr, exists := configMap["operation_timeout"]; exists {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid operation_timeout: %s", timeoutStr)
+		}
+		config.OperationTimeout = timeout
+	}
+
+	// Optional: skip files outside a size range
+	if sizeStr, exists := configMap["max_file_size"]; exists {
+		size, err := parseSize(sizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_file_size: %v", err)
+		}
+		config.MaxFileSize = size
+	}
+	if sizeStr, exists := configMap["min_file_size"]; exists {
+		size, err := parseSize(sizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_file_size: %v", err)
+		}
+		config.MinFileSize = size
+	}
+
+	// Optional: cap on upload throughput
+	if bandwidthStr, exists := configMap["max_bandwidth"]; exists {
+		bandwidth, err := parseBandwidth(bandwidthStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_bandwidth: %v", err)
+		}
+		config.MaxBandwidth = bandwidth
+	}
+
+	// Optional: multipart upload sizes, in bytes
+	if thresholdStr, exists := configMap["multipart_threshold"]; exists {
+		threshold, err := strconv.ParseInt(thresholdStr, 10, 64)
+		if err != nil || threshold < 1 {
+			return nil, fmt.Errorf("invalid multipart_threshold: %s", thresholdStr)
+		}
+		config.MultipartThreshold = threshold
+	}
+	if partSizeStr, exists := configMap["part_size"]; exists {
+		partSize, err := strconv.ParseInt(partSizeStr, 10, 64)
+		if err != nil || partSize < manager.MinUploadPartSize {
+			return nil, fmt.Errorf("invalid part_size: %s (minimum %d)", partSizeStr, manager.MinUploadPartSize)
+		}
+		config.PartSize = partSize
+	}
+	if ageStr, exists := configMap["abort_stale_multiparts"]; exists {
+		age, err := time.ParseDuration(ageStr)
+		if err != nil || age < 0 {
+			return nil, fmt.Errorf("invalid abort_stale_multiparts: %s", ageStr)
+		}
+		config.AbortStaleMultiparts = age
+	}
+
+	// Optional: copy duplicate files server-side instead of uploading them again
+	if dedupeStr, exists := configMap["dedupe"]; exists {
+		dedupe, err := strconv.ParseBool(dedupeStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dedupe: %s", dedupeStr)
+		}
+		config.Dedupe = dedupe
+	}
+
+	// Optional: copy renamed files from their old key instead of uploading them
+	if renamesStr, exists := configMap["detect_renames"]; exists {
+		detectRenames, err := strconv.ParseBool(renamesStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid detect_renames: %s", renamesStr)
+		}
+		config.DetectRenames = detectRenames
+	}
+
+	// Optional: keep syncing past files that fail, reporting them all at the end
+	if continueStr, exists := configMap["continue_on_error"]; exists {
+		continueOnError, err := strconv.ParseBool(continueStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid continue_on_error: %s", continueStr)
+		}
+		config.ContinueOnError = continueOnError
+	}
+
+	// Optional: write JSON manifests as markers and use them to skip unchanged files
+	if manifestStr, exists := configMap["manifest_mode"]; exists {
+		manifestMode, err := strconv.ParseBool(manifestStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest_mode: %s", manifestStr)
+		}
+		config.ManifestMode = manifestMode
+	}
+	if config.ManifestMode && !config.WriteMarkers {
+		return nil, fmt.Errorf("manifest_mode requires write_markers")
+	}
+
+	// Optional: write Prefix/_SUCCESS once the whole tree is verified
+	if successStr, exists := configMap["success_marker"]; exists {
+		success, err := strconv.ParseBool(successStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid success_marker: %s", successStr)
+		}
+		config.SuccessMarker = success
+	}
+
+	// Optional: pricing used by the plan command
+	costs := map[string]*float64{
+		"cost_per_1k_put":  &config.CostPer1kPut,
+		"cost_per_1k_list": &config.CostPer1kList,
+		"cost_per_1k_head": &config.CostPer1kHead,
+		"cost_per_gb":      &config.CostPerGB,
+	}
+	for key, field := range costs {
+		if costStr, exists := configMap[key]; exists {
+			cost, err := strconv.ParseFloat(costStr, 64)
+			if err != nil || cost < 0 {
+				return nil, fmt.Errorf("invalid %s: %s", key, costStr)
+			}
+			*field = cost
+		}
+	}
+
+	// Optional: write a JSON summary of each sync to this file
+	config.ReportPath = os.ExpandEnv(configMap["report_path"])
+
+	// Optional: upload each run's log to the bucket
+	config.LogToS3Prefix = configMap["log_to_s3_prefix"]
+	if keepStr, exists := configMap["log_s3_keep"]; exists {
+		keep, err := strconv.Atoi(keepStr)
+		if err != nil || keep < 0 {
+			return nil, fmt.Errorf("invalid log_s3_keep: %s", keepStr)
+		}
+		config.LogS3Keep = keep
+	}
+
+	// Optional: log format and verbosity
+	if logFormat, exists := configMap["log_format"]; exists {
+		switch logFormat {
+		case logFormatText, logFormatJSON:
+			config.LogFormat = logFormat
+		default:
+			return nil, fmt.Errorf("invalid log_format: %s", logFormat)
+		}
+	}
+	if logLevel, exists := configMap["log_level"]; exists {
+		level, ok := logLevels[logLevel]
+		if !ok {
+			return nil, fmt.Errorf("invalid log_level: %s", logLevel)
+		}
+		config.LogLevel = level
+	}
+
+	// Optional: how to decide whether an existing remote object is up to date
+	if compare, exists := configMap["compare"]; exists {
+		switch compare {
+		case compareExists, compareSize, compareMtime, compareChecksum, compareETag:
+			config.Compare = compare
+		default:
+			return nil, fmt.Errorf("invalid compare mode: %s", compare)
+		}
+	}
+
+	// Optional: overwrite policy for existing objects, replacing compare
+	if overwrite, exists := configMap["overwrite"]; exists {
+		switch overwrite {
+		case overwriteNever, overwriteAlways, overwriteIfNewer:
+			config.Overwrite = overwrite
+		default:
+			return nil, fmt.Errorf("invalid overwrite: %s", overwrite)
+		}
+	}
+
+	// Optional: download from S3 as well as (or instead of) uploading
+	if direction, exists := configMap["direction"]; exists {
+		switch direction {
+		case directionUp, directionDown, directionBoth:
+			config.Direction = direction
+		default:
+			return nil, fmt.Errorf("invalid direction: %s", direction)
+		}
+	}
+	if conflict, exists := configMap["conflict"]; exists {
+		switch conflict {
+		case conflictNewer, conflictLocal, conflictRemote:
+			config.Conflict = conflict
+		default:
+			return nil, fmt.Errorf("invalid conflict: %s", conflict)
+		}
+	}
+	// Rewritten keys can't be mapped back to local paths
+	if config.KeyRewrite != nil && config.Direction != directionUp {
+		return nil, fmt.Errorf("key_rewrite can't be used with direction=%s", config.Direction)
+	}
+
+	// Optional: precomputed sha256sum-style index used by compare=checksum
+	config.ChecksumIndex = configMap["checksum_index"]
+
+	if toleranceStr, exists := configMap["mtime_tolerance"]; exists {
+		tolerance, err := time.ParseDuration(toleranceStr)
+		if err != nil || tolerance < 0 {
+			return nil, fmt.Errorf("invalid mtime_tolerance: %s", toleranceStr)
+		}
+		config.MtimeTolerance = tolerance
+	}
+
+	// Optional: prioritize uploads during large syncs
+	if order, exists := configMap["upload_order"]; exists {
+		switch order {
+		case orderPath, orderMtimeDesc, orderSizeAsc, orderSizeDesc:
+			config.UploadOrder = order
+		default:
+			return nil, fmt.Errorf("invalid upload_order: %s", order)
+		}
+	}
+	if chunkStr, exists := configMap["upload_order_chunk"]; exists {
+		chunk, err := strconv.Atoi(chunkStr)
+		if err != nil || chunk < 0 {
+			return nil, fmt.Errorf("invalid upload_order_chunk: %s", chunkStr)
+		}
+		config.UploadOrderChunk = chunk
+	}
+
+	// Optional: comma-separated glob patterns selecting which files sync
+	config.Exclude, err = compileGlobs(configMap["exclude"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude: %v", err)
+	}
+	config.Include, err = compileGlobs(configMap["include"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid include: %v", err)
+	}
+
+	// Optional: skip paths ignored by .gitignore files in the tree
+	if gitignoreStr, exists := configMap["respect_gitignore"]; exists {
+		respect, err := strconv.ParseBool(gitignoreStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid respect_gitignore: %s", gitignoreStr)
+		}
+		config.RespectGitignore = respect
+	}
+
+	// Optional: only sync files up to this many directories below local_dir
+	if depthStr, exists := configMap["max_depth"]; exists {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil || depth < 0 {
+			return nil, fmt.Errorf("invalid max_depth: %s", depthStr)
+		}
+		config.MaxDepth = depth
+	}
+
+	// Optional: Content-Type overrides by extension, e.g. content_type.css=text/css
+	config.ContentTypes = make(map[string]string)
+	for key, value := range configMap {
+		if ext, isContentType := strings.CutPrefix(key, "content_type."); isContentType {
+			config.ContentTypes["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = value
+		}
+	}
+
+	// Optional: Cache-Control for uploads, overridable by extension,
+	// e.g. cache_control.html=no-cache
+	config.CacheControl = configMap["cache_control"]
+	config.CacheControlByExt = make(map[string]string)
+	for key, value := range configMap {
+		if ext, isCacheControl := strings.CutPrefix(key, "cache_control."); isCacheControl {
+			config.CacheControlByExt["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = value
+		}
+	}
+
+	// Optional: Expires header, as a duration after upload or a fixed RFC 3339/RFC 1123 time
+	if expiresStr, exists := configMap["expires"]; exists && expiresStr != "" {
+		if after, err := time.ParseDuration(expiresStr); err == nil && after > 0 {
+			config.ExpiresAfter = after
+		} else if at, err := time.Parse(time.RFC3339, expiresStr); err == nil {
+			config.ExpiresAt = at
+		} else if at, err := time.Parse(time.RFC1123, expiresStr); err == nil {
+			config.ExpiresAt = at
+		} else {
+			return nil, fmt.Errorf("invalid expires: %s", expiresStr)
+		}
+	}
+
+	// Optional: object tags for uploaded files, e.g. tags=team=data&env=prod
+	if tagsStr, exists := configMap["tags"]; exists {
+		config.Tags, err = parseTags(tagsStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tags: %v", err)
+		}
+	}
+
+	// Optional: strip BOMs and convert CRLF to LF for these text extensions
+	config.NormalizeText = make(map[string]bool)
+	for _, ext := range splitList(configMap["normalize_text"]) {
+		config.NormalizeText[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+
+	// Optional: gzip these extensions before upload and serve them with Content-Encoding: gzip
+	config.GzipExtensions = make(map[string]bool)
+	for _, ext := range splitList(configMap["gzip_extensions"]) {
+		config.GzipExtensions[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+
+	// Optional: static website headers. Redirects are keyed by relative path,
+	// e.g. website_redirect.old/index.html=/new/index.html
+	config.ContentLanguage = configMap["content_language"]
+	config.WebsiteRedirects = make(map[string]string)
+	for key, value := range configMap {
+		relPath, isRedirect := strings.CutPrefix(key, "website_redirect.")
+		if !isRedirect {
+			continue
+		}
+		if !strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			return nil, fmt.Errorf("invalid website_redirect for %s: must start with /, http:// or https://", relPath)
+		}
+		config.WebsiteRedirects[relPath] = value
+	}
+
+	// Optional: what to do with FIFOs, sockets and device nodes
+	if onSpecial, exists := configMap["on_special_file"]; exists {
+		if onSpecial != "skip" && onSpecial != "fail" {
+			return nil, fmt.Errorf("invalid on_special_file: %s", onSpecial)
+		}
+		config.OnSpecialFile = onSpecial
+	}
+
+	// Optional: skip, follow or fail on symlinks
+	if symlinks, exists := configMap["symlinks"]; exists {
+		if symlinks != symlinksSkip && symlinks != symlinksFollow && symlinks != symlinksError {
+			return nil, fmt.Errorf("invalid symlinks: %s", symlinks)
+		}
+		config.Symlinks = symlinks
+	}
+
+	// Optional: guard against symlinks that point outside local_dir
+	if onEscaping, exists := configMap["on_escaping_symlink"]; exists {
+		if onEscaping != "skip" && onEscaping != "fail" {
+			return nil, fmt.Errorf("invalid on_escaping_symlink: %s", onEscaping)
+		}
+		config.OnEscapingSymlink = onEscaping
+	}
+	config.SymlinkAllowedRoots = splitList(configMap["symlink_allowed_roots"])
+
+	// Optional: relative path prefixes that must never be deleted from S3
+	config.NoDeletePrefixes = splitList(configMap["no_delete_prefixes"])
+	config.Keep, err = compileGlobs(configMap["keep"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid keep: %v", err)
+	}
+
+	// Optional: sync an empty local_dir instead of failing
+	if allowStr, exists := configMap["allow_empty"]; exists {
+		allowEmpty, err := strconv.ParseBool(allowStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow_empty: %s", allowStr)
+		}
+		config.AllowEmpty = allowEmpty
+	}
+
+	// Optional: remove objects whose local file is gone
+	if deleteStr, exists := configMap["delete_removed"]; exists {
+		deleteRemoved, err := strconv.ParseBool(deleteStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delete_removed: %s", deleteStr)
+		}
+		config.DeleteRemoved = deleteRemoved
+	}
+
+	// Optional: move deleted objects under this prefix instead of discarding them
+	config.TrashPrefix = os.ExpandEnv(configMap["trash_prefix"])
+
+	// Optional: refuse deletes larger than a count ("500") or a share of the prefix ("10%")
+	if maxDeleteStr, exists := configMap["max_delete"]; exists {
+		if percentStr, isPercent := strings.CutSuffix(maxDeleteStr, "%"); isPercent {
+			percent, err := strconv.ParseFloat(percentStr, 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return nil, fmt.Errorf("invalid max_delete: %s", maxDeleteStr)
+			}
+			config.MaxDeletePercent = percent
+		} else {
+			count, err := strconv.Atoi(maxDeleteStr)
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid max_delete: %s", maxDeleteStr)
+			}
+			config.MaxDelete = count
+		}
+	}
+
+	// Optional: serve Prometheus metrics on this address
+	if metricsAddr, exists := configMap["metrics_addr"]; exists {
+		if _, _, err := net.SplitHostPort(metricsAddr); err != nil {
+			return nil, fmt.Errorf("invalid metrics_addr: %s (expected host:port)", metricsAddr)
+		}
+		config.MetricsAddr = metricsAddr
+	}
+
+	// Optional: serve liveness and readiness probes on this address
+	if healthAddr, exists := configMap["health_addr"]; exists {
+		if _, _, err := net.SplitHostPort(healthAddr); err != nil {
+			return nil, fmt.Errorf("invalid health_addr: %s (expected host:port)", healthAddr)
+		}
+		config.HealthAddr = healthAddr
+	}
+
+	// Optional: refuse to run against buckets outside an approved set.
+	// Both the config key and the SYNCD_ALLOWED_BUCKETS env var are enforced when set.
+	config.AllowedBuckets = splitList(configMap["allowed_buckets"])
+	for _, allowed := range [][]string{config.AllowedBuckets, splitList(os.Getenv("SYNCD_ALLOWED_BUCKETS"))} {
+		if len(allowed) > 0 && !slices.Contains(allowed, config.BucketName) {
+			return nil, fmt.Errorf("bucket %s is not in allowed_buckets %v", config.BucketName, allowed)
+		}
+	}
+
+	return config, nil
+}
+
+// normalizePrefix returns prefix with slash separators, no leading slash and a single
+// trailing slash, so a prefix of "photos" never matches keys under "photos-old/"
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(prefix, "\\", "/")), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// resolveLocalDir expands a leading ~ and environment variables in local_dir and
+// makes it absolute
+func resolveLocalDir(value string) (string, error) {
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		value = home + value[1:]
+	}
+	return filepath.Abs(os.ExpandEnv(value))
+}
+
+// splitList parses a comma-separated config value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// S3 object tagging limits
+const (
+	maxTags           = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// parseTags parses a URL query-style tag set such as "team=data&env=prod"
+// and checks it against S3's tagging limits
+func parseTags(value string) (url.Values, error) {
+	tags, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("%d tags given, S3 allows at most %d", len(tags), maxTags)
+	}
+	for key, values := range tags {
+		if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength {
+			return nil, fmt.Errorf("tag key %q must be 1-%d characters", key, maxTagKeyLength)
+		}
+		if len(values) > 1 {
+			return nil, fmt.Errorf("tag %q is set more than once", key)
+		}
+		if utf8.RuneCountInString(values[0]) > maxTagValueLength {
+			return nil, fmt.Errorf("value of tag %q is longer than %d characters", key, maxTagValueLength)
+		}
+	}
+	return tags, nil
+}
+
+// objectKey builds the S3 key for a path relative to the configured prefix
+func objectKey(prefix, relPath string) string {
+	key := filepath.Join(prefix, relPath)
+	return strings.ReplaceAll(key, "\\", "/")
+}
+
+// remoteRelPath maps a local relative path to its key relative to the prefix,
+// applying key_rewrite when configured
+func remoteRelPath(cfg *SyncConfig, relPath string) string {
+	if cfg.KeyRewrite == nil {
+		return relPath
+	}
+	return cfg.KeyRewrite.ReplaceAllString(relPath, cfg.KeyRewriteReplacement)
+}
+
+// optionalString returns nil for empty values so unset config leaves SDK fields unset
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// headS3Object returns the object's metadata, or nil if it doesn't exist.
+// Any other failure, such as AccessDenied or a 5xx, is returned as an error.
+// SSE-C headers are included since S3 rejects HEADs of SSE-C objects without them.
+func headS3Object(ctx context.Context, client S3API, cfg *SyncConfig, key string) (*s3.HeadObjectOutput, error) {
+	var output *s3.HeadObjectOutput
+	err := withRetry(ctx, cfg.MaxRetries, "HEAD of "+key, func() error {
+		opCtx, cancel := operationContext(ctx, cfg)
+		defer cancel()
+		var err error
+		output, err = client.HeadObject(opCtx, &s3.HeadObjectInput{
+			Bucket:               &cfg.BucketName,
+			Key:                  &key,
+			SSECustomerAlgorithm: optionalString(cfg.SSECustomerAlgorithm),
+			SSECustomerKey:       optionalString(cfg.SSECustomerKey),
+			SSECustomerKeyMD5:    optionalString(cfg.SSECustomerKeyMD5),
+		})
+		return err
+	})
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// etagIsMD5 reports whether objects uploaded with cfg have their content MD5 as
+// ETag, which isn't the case with SSE-KMS or SSE-C (or for multipart uploads)
+func etagIsMD5(cfg *SyncConfig) bool {
+	return cfg.SSE != string(types.ServerSideEncryptionAwsKms) && cfg.SSECustomerKey == ""
+}
+
+// needsUpload decides whether a local file must be uploaded according to cfg.Compare.
+// Decisions are made from the up-front remote listing, which includes ETags; compare=mtime
+// and compare=checksum need a HeadObject, and only for files whose size already matches.
+func needsUpload(ctx context.Context, cfg *SyncConfig, state *syncState, s3Key string, f *localFile) (bool, error) {
+	remote, exists := state.remoteFiles[remoteRelPath(cfg, f.relPath)]
+	if !exists {
+		return true, nil
+	}
+
+	switch cfg.Overwrite {
+	case overwriteNever:
+		return false, nil
+	case overwriteAlways:
+		return true, nil
+	case overwriteIfNewer:
+		// The listing's LastModified is the upload time, so synced files are never newer
+		if f.info.ModTime().After(remote.lastModified.Add(cfg.MtimeTolerance)) {
+			slog.Debug("Local file is newer than the object, re-uploading", "key", s3Key)
+			return true, nil
+		}
+		return false, nil
+	}
+
+	// Compare against the normalized or gzipped form so those files aren't re-uploaded every run
+	if err := loadContent(cfg, f); err != nil {
+		return false, err
+	}
+
+	// Unchanged since the last verified sync according to its manifest
+	if entry, recorded := state.oldManifest[f.relPath]; recorded && entry.unchanged(f) {
+		return headersChanged(ctx, cfg, state, s3Key, f, nil)
+	}
+
+	// Both sides may have changed, so the conflict policy decides instead of compare
+	if cfg.Direction == directionBoth {
+		return uploadWins(ctx, cfg, state, s3Key, f)
+	}
+
+	if cfg.Compare == compareExists {
+		return headersChanged(ctx, cfg, state, s3Key, f, nil)
+	}
+
+	// ETags of SSE-KMS and SSE-C objects aren't a content MD5, so compare=etag
+	// falls back to size and mtime for them
+	compare := cfg.Compare
+	if compare == compareETag && !etagIsMD5(cfg) {
+		compare = compareMtime
+	}
+
+	if remote.size != f.size() {
+		slog.Debug("Size changed, re-uploading", "key", s3Key)
+		return true, nil
+	}
+
+	if compare == compareSize {
+		return headersChanged(ctx, cfg, state, s3Key, f, nil)
+	}
+
+	if compare == compareETag {
+		// Multipart ETags aren't a content MD5, so the size check above is all we can do
+		etag := strings.Trim(remote.etag, "\"")
+		if isMultipartETag(etag) {
+			slog.Debug("Multipart ETag, comparing by size only", "key", s3Key)
+			return headersChanged(ctx, cfg, state, s3Key, f, nil)
+		}
+		sum, err := localMD5(f)
+		if err != nil {
+			return false, err
+		}
+		if sum != etag {
+			slog.Debug("Content changed (ETag mismatch), re-uploading", "key", s3Key)
+			return true, nil
+		}
+		return headersChanged(ctx, cfg, state, s3Key, f, nil)
+	}
+
+	// Listings don't include user metadata, so fetch it for this object
+	state.countHead()
+	head, err := state.backend.Head(ctx, s3Key)
+	if err != nil {
+		return false, err
+	}
+	if head == nil {
+		return true, nil
+	}
+
+	switch compare {
+	case compareMtime:
+		if mtimeChanged(f.info.ModTime(), head.Metadata, cfg.MtimeTolerance) {
+			slog.Debug("Modification time changed, re-uploading", "key", s3Key)
+			return true, nil
+		}
+	case compareChecksum:
+		sum, err := localSHA256(state.checksumIndex, f)
+		if err != nil {
+			return false, err
+		}
+		remoteSum, recorded := head.Metadata[sha256MetadataKey]
+		if !recorded {
+			slog.Debug("No checksum recorded, re-uploading", "key", s3Key)
+			return true, nil
+		}
+		if remoteSum != sum {
+			slog.Debug("Checksum changed, re-uploading", "key", s3Key)
+			return true, nil
+		}
+	}
+
+	return headersChanged(ctx, cfg, state, s3Key, f, head)
+}
+
+// headersChanged reports whether the object's Cache-Control, or the Content-Type
+// and metadata set by f's sidecar file, differ from what an upload of f would set,
+// fetching head when the caller hasn't. Objects are only compared when a Cache-Control
+// is configured for them or they have a sidecar.
+func headersChanged(ctx context.Context, cfg *SyncConfig, state *syncState, s3Key string, f *localFile, head *ObjectInfo) (bool, error) {
+	if err := loadSidecar(f); err != nil {
+		return false, err
+	}
+	cacheControl := cacheControlFor(cfg, f)
+	if cacheControl == "" && f.meta == nil {
+		return false, nil
+	}
+	if head == nil {
+		state.countHead()
+		var err error
+		head, err = state.backend.Head(ctx, s3Key)
+		if err != nil {
+			return false, err
+		}
+		if head == nil {
+			return true, nil
+		}
+	}
+
+	if cacheControl != "" && head.CacheControl != cacheControl {
+		slog.Debug("Cache-Control changed, re-uploading", "key", s3Key)
+		return true, nil
+	}
+	if f.meta == nil {
+		return false, nil
+	}
+	if f.meta.ContentType != "" && head.ContentType != f.meta.ContentType {
+		slog.Debug("Content-Type changed, re-uploading", "key", s3Key)
+		return true, nil
+	}
+	for key, value := range f.meta.Metadata {
+		if head.Metadata[key] != value {
+			slog.Debug("Metadata changed, re-uploading", "key", s3Key)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cacheControlFor returns the Cache-Control for f: its sidecar's, its extension's
+// override, or the default
+func cacheControlFor(cfg *SyncConfig, f *localFile) string {
+	if f.meta != nil && f.meta.CacheControl != "" {
+		return f.meta.CacheControl
+	}
+	if cacheControl, exists := cfg.CacheControlByExt[strings.ToLower(filepath.Ext(f.relPath))]; exists {
+		return cacheControl
+	}
+	return cfg.CacheControl
+}
+
+// expiresFor returns the Expires header for an upload starting now, or nil when none is configured
+func expiresFor(cfg *SyncConfig) *time.Time {
+	switch {
+	case cfg.ExpiresAfter > 0:
+		return aws.Time(time.Now().Add(cfg.ExpiresAfter))
+	case !cfg.ExpiresAt.IsZero():
+		return &cfg.ExpiresAt
+	}
+	return nil
+}
+
+// mtimeChanged reports whether the local mtime differs from the stored mtime metadata
+// by more than tolerance. Objects without mtime metadata are treated as unchanged.
+func mtimeChanged(localMtime time.Time, metadata map[string]string, tolerance time.Duration) bool {
+	stored, exists := metadata[mtimeMetadataKey]
+	if !exists {
+		return false
+	}
+	remoteMtime, err := time.Parse(time.RFC3339, stored)
+	if err != nil {
+		return false
+	}
+
+	// Metadata only keeps whole seconds, so drop sub-second precision locally too
+	diff := localMtime.Truncate(time.Second).Sub(remoteMtime)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff > tolerance
+}
+
+// formatMtime renders a file mtime for storage in object metadata
+func formatMtime(mtime time.Time) string {
+	return mtime.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// listFiles returns the relative paths of all files under cfg.LocalDir that would be
+// synced, with the info the walk found them with (the target's, for followed symlinks)
+func listFiles(cfg *SyncConfig) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	filter := newWalkFilter(cfg)
+	filter.quiet = true // the upload walk already reports skipped files
+	err := walkLocalDir(cfg, func(path, relPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if include, err := filter.include(relPath, info); !include {
+			return err
+		}
+		files[relPath] = info
+		return nil
+	})
+	return files, err
+}
+
+// remoteObject holds the listing metadata of an object in the bucket
+type remoteObject struct {
+	size         int64
+	etag         string
+	lastModified time.Time
+}
+
+// listS3Files lists every object under prefix, keyed by path relative to the prefix
+func listS3Files(ctx context.Context, backend Backend, prefix string, markerFile string) (map[string]remoteObject, error) {
+	objects, err := backend.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]remoteObject)
+	for _, obj := range objects {
+		key := obj.Key
+		// Remove prefix to get relative path
+		if prefix != "" {
+			key = strings.TrimPrefix(key, prefix)
+			key = strings.TrimPrefix(key, "/")
+		}
+		// Don't include sync marker files in comparison
+		if !strings.HasSuffix(key, markerFile) && key != successMarkerName {
+			files[key] = remoteObject{
+				size:         obj.Size,
+				etag:         obj.ETag,
+				lastModified: obj.LastModified,
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// localFile is a file found while walking LocalDir
+type localFile struct {
+	path    string // local filesystem path
+	relPath string // slash-separated path relative to LocalDir
+	info    os.FileInfo
+	sha256  string // hex SHA-256, filled in once computed
+
+	// content holds normalized text or gzipped bytes to upload instead of the file
+	// on disk (normalize_text, gzip_extensions)
+	content       []byte
+	gzipped       bool
+	contentLoaded bool
+	// decodedSize and decodedMD5 describe gzipped content before compression
+	decodedSize int64
+	decodedMD5  string
+
+	// meta holds the settings from the file's sidecar, nil when it has none
+	meta          *objectMeta
+	sidecarLoaded bool
+}
+
+// size returns the size of what will be uploaded for f
+func (f *localFile) size() int64 {
+	if f.content != nil {
+		return int64(len(f.content))
+	}
+	return f.info.Size()
+}
+
+// syncState holds what a sync gathers up front and shares across upload decisions.
+// The maps are read-only while uploads run; counters are updated under mu.
+type syncState struct {
+	remoteFiles   map[string]remoteObject // listing of the prefix taken at the start of the sync
+	checksumIndex *checksumIndex          // nil unless checksum_index is configured
+	prioritized   map[string]bool         // files already handled by the prioritize_failed pass
+	failedSubdirs *subdirSet              // subdirectories that failed verification last run
+	backend       Backend                 // listings, markers and manifests
+	// Entries from the manifest markers already in S3 (manifest_mode)
+	oldManifest map[string]manifestEntry
+	// Upload rate limit shared by all workers, nil unless max_bandwidth is set
+	bandwidth *rate.Limiter
+	// SHA-256 -> key of files uploaded this run, for dedupe
+	dedupeKeys map[string]string
+	// Local relative path -> relative path of the object it was renamed from (detect_renames)
+	renames map[string]string
+	// Where the upload pass draws its progress line, nil for none
+	progress io.Writer
+	// Called with the local path of every file the download pass writes, if set
+	onDownload func(localPath string)
+
+	mu            sync.Mutex
+	uploaded      int   // files uploaded (or that would be, in dry-run mode)
+	uploadedBytes int64 // bytes uploaded (or that would be, in dry-run mode)
+	skipped       int   // files already up to date
+	headRequests  int   // HeadObject calls made for upload decisions
+	deleted       int   // objects deleted (or that would be, in dry-run mode)
+	markers       int   // marker files written (or that would be, in dry-run mode)
+	processed     int   // files the upload pass is done with, for progress
+
+	// Per-file failures collected with continue_on_error
+	failures []error
+	// Files seen this run, for the manifests written as markers (manifest_mode)
+	manifest map[string]manifestEntry
+}
+
+// result summarizes the counters as a SyncResult
+func (s *syncState) result(duration time.Duration) SyncResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SyncResult{
+		FilesUploaded: s.uploaded,
+		FilesDeleted:  s.deleted,
+		FilesSkipped:  s.skipped,
+		BytesUploaded: s.uploadedBytes,
+		Errors:        slices.Clone(s.failures),
+		Duration:      duration,
+	}
+}
+
+// tolerate records a per-file failure and returns nil when continue_on_error lets the
+// sync carry on. Shutdown and fatal errors such as AccessDenied still abort the sync.
+func (s *syncState) tolerate(ctx context.Context, cfg *SyncConfig, path string, err error) error {
+	if err == nil || !cfg.ContinueOnError || ctx.Err() != nil || IsFatal(err) {
+		return err
+	}
+	slog.Error("Error syncing file, continuing", "path", path, "err", err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &fileError{path: path, err: err})
+	return nil
+}
+
+// countUpload records an uploaded (or, in dry-run mode, planned) file
+func (s *syncState) countUpload(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploaded++
+	s.uploadedBytes += size
+}
+
+// countSkip records a file that was already up to date
+func (s *syncState) countSkip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+}
+
+// countHead records a HeadObject call made for an upload decision
+func (s *syncState) countHead() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headRequests++
+}
+
+// subdirSet is a concurrency-safe set of subdirectories carried between sync runs
+type subdirSet struct {
+	mu      sync.Mutex
+	subdirs []string
+}
+
+func (s *subdirSet) set(subdirs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subdirs = subdirs
+}
+
+func (s *subdirSet) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.subdirs)
+}
+
+// sortLocalFiles orders files for upload according to an upload_order mode
+func sortLocalFiles(files []localFile, order string) {
+	switch order {
+	case orderMtimeDesc:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].info.ModTime().After(files[j].info.ModTime())
+		})
+	case orderSizeAsc:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].info.Size() < files[j].info.Size()
+		})
+	case orderSizeDesc:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].info.Size() > files[j].info.Size()
+		})
+	}
+}
+
+// uploadIfNeeded uploads a single local file when it is missing or out of date in S3
+func uploadIfNeeded(ctx context.Context, cfg *SyncConfig, state *syncState, f *localFile) error {
+	// Create the S3 key
+	s3Key := objectKey(cfg.Prefix, remoteRelPath(cfg, f.relPath))
+
+	// Already handled by the prioritize_failed pass this run
+	if state.prioritized[f.relPath] {
+		return nil
+	}
+
+	// Check if file is missing or out of date in S3
+	upload, err := needsUpload(ctx, cfg, state, s3Key, f)
+	if err != nil {
+		return err
+	}
+	if !upload {
+		state.countSkip()
+		if cfg.ManifestMode && !cfg.DryRun {
+			return state.recordManifest(f)
+		}
+		return nil
+	}
+
+	if cfg.DryRun {
+		slog.Info("[dry-run] Would upload", "path", f.path, "key", s3Key)
+		state.countUpload(f.size())
+		return nil
+	}
+
+	if err := loadContent(cfg, f); err != nil {
+		return err
+	}
+	if err := loadSidecar(f); err != nil {
+		return err
+	}
+
+	// Sidecar metadata can't replace the entries syncd relies on
+	metadata := make(map[string]string)
+	if f.meta != nil {
+		maps.Copy(metadata, f.meta.Metadata)
+	}
+	metadata[mtimeMetadataKey] = formatMtime(f.info.ModTime())
+	// Let pulls compare gzipped objects with local files
+	if f.gzipped {
+		metadata[decodedSizeMetadataKey] = strconv.FormatInt(f.decodedSize, 10)
+		metadata[decodedMD5MetadataKey] = f.decodedMD5
+	}
+	// Record the checksum so later compare=checksum runs can skip unchanged files
+	if cfg.Compare == compareChecksum {
+		sum, err := localSHA256(state.checksumIndex, f)
+		if err != nil {
+			return err
+		}
+		metadata[sha256MetadataKey] = sum
+	}
+
+	// File is missing or changed in S3, upload it
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	contentType, err := detectContentType(cfg, f.relPath, file)
+	if err != nil {
+		return err
+	}
+	if f.meta != nil && f.meta.ContentType != "" {
+		contentType = f.meta.ContentType
+	}
+
+	var body io.ReadSeeker = file
+	if f.content != nil {
+		body = bytes.NewReader(f.content)
+	}
+
+	headers := FileHeaders{
+		Metadata:         metadata,
+		ContentType:      contentType,
+		CacheControl:     cacheControlFor(cfg, f),
+		RedirectLocation: cfg.WebsiteRedirects[f.relPath],
+	}
+	if f.gzipped {
+		headers.ContentEncoding = contentEncodingGzip
+	}
+
+	// Copy a renamed file from its old key instead of sending it again
+	if oldPath, exists := state.renames[f.relPath]; exists && f.size() <= maxCopySize {
+		sourceKey := objectKey(cfg.Prefix, oldPath)
+		err := state.backend.CopyFile(ctx, sourceKey, s3Key, headers)
+		if err == nil {
+			slog.Debug("Copied renamed file", "path", f.path, "key", s3Key, "source", sourceKey)
+			state.countUpload(0)
+			if cfg.ManifestMode {
+				return state.recordManifest(f)
+			}
+			return nil
+		}
+		slog.Warn("Error copying renamed file, uploading it instead", "path", f.path, "source", sourceKey, "err", err)
+	}
+
+	// Copy identical content uploaded earlier this run instead of sending it again
+	var sum string
+	if cfg.Dedupe && f.size() <= maxCopySize {
+		if sum, err = localSHA256(state.checksumIndex, f); err != nil {
+			return err
+		}
+		if sourceKey, exists := state.dedupeSource(sum); exists {
+			err := state.backend.CopyFile(ctx, sourceKey, s3Key, headers)
+			if err == nil {
+				slog.Debug("Copied duplicate file", "path", f.path, "key", s3Key, "source", sourceKey)
+				state.countUpload(0)
+				if cfg.ManifestMode {
+					return state.recordManifest(f)
+				}
+				return nil
+			}
+			slog.Warn("Error copying duplicate file, uploading it instead", "path", f.path, "source", sourceKey, "err", err)
+		}
+	}
+
+	if headers.MD5, err = uploadMD5(cfg, f); err != nil {
+		return err
+	}
+	if state.bandwidth != nil {
+		body = &throttledReader{ctx: context.WithoutCancel(ctx), body: body, limiter: state.bandwidth}
+	}
+	if err := state.backend.PutFile(ctx, s3Key, body, f.size(), headers); err != nil {
+		slog.Error("Error uploading", "path", f.path, "key", s3Key, "err", err)
+		return err
+	}
+
+	slog.Debug("Uploaded file", "path", f.path, "key", s3Key)
+	state.countUpload(f.size())
+	if sum != "" {
+		state.recordUploadHash(sum, s3Key)
+	}
+	if cfg.ManifestMode {
+		return state.recordManifest(f)
+	}
+	return nil
+}
+
+// detectContentType picks a Content-Type from the configured overrides, then the
+// file extension, and finally by sniffing the first 512 bytes of content.
+// The file is rewound afterwards so it can be used as the upload body.
+func detectContentType(cfg *SyncConfig, relPath string, file *os.File) (string, error) {
+	ext := strings.ToLower(filepath.Ext(relPath))
+	if contentType, exists := cfg.ContentTypes[ext]; exists {
+		return contentType, nil
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType, nil
+	}
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// syncPriorityDirs uploads the files directly inside subdirs ahead of the full walk,
+// so subdirectories that failed last run recover as quickly as possible
+func syncPriorityDirs(ctx context.Context, cfg *SyncConfig, state *syncState, subdirs []string) error {
+	filter := newWalkFilter(cfg)
+	for _, subdir := range subdirs {
+		dir := filepath.Join(cfg.LocalDir, filepath.FromSlash(subdir))
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			slog.Warn("Skipping previously failed subdirectory", "subdir", subdir, "err", err)
+			continue
+		}
+
+		slog.Info("Retrying previously failed subdirectory first", "subdir", subdir)
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			relPath := path.Join(subdir, entry.Name())
+			if info.Mode()&os.ModeSymlink != 0 && cfg.Symlinks == symlinksFollow {
+				if info, err = followSymlink(cfg, filepath.Join(dir, entry.Name())); err != nil {
+					return err
+				}
+				if info == nil {
+					continue
+				}
+			}
+			if include, err := filter.include(relPath, info); !include {
+				if err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			f := localFile{path: filepath.Join(dir, entry.Name()), relPath: relPath, info: info}
+			err = uploadIfNeeded(ctx, cfg, state, &f)
+			if err := state.tolerate(ctx, cfg, f.path, err); err != nil {
+				return err
+			}
+			state.prioritized[relPath] = true
+		}
+	}
+	return nil
+}
+
+// awaitObject re-checks a file missing from the verification listing up to
+// cfg.VerifyRetries times, cfg.VerifyDelay apart, and reports whether it showed up
+func awaitObject(ctx context.Context, backend Backend, cfg *SyncConfig, relPath string) bool {
+	s3Key := objectKey(cfg.Prefix, remoteRelPath(cfg, relPath))
+	for attempt := 1; attempt <= cfg.VerifyRetries; attempt++ {
+		select {
+		case <-time.After(cfg.VerifyDelay):
+		case <-ctx.Done():
+			return false
+		}
+		object, err := backend.Head(ctx, s3Key)
+		if err != nil {
+			slog.Warn("Error re-checking file missing in S3", "key", s3Key, "err", err)
+			continue
+		}
+		if object != nil {
+			slog.Warn("File appeared in S3 only after re-checking", "key", s3Key, "attempts", attempt)
+			return true
+		}
+	}
+	return false
+}
+
+// writeMarker creates the sync marker file for a verified subdirectory, listing the
+// keys (relative to the prefix) of the files it certifies, or as a JSON manifest of
+// them in manifest_mode
+func writeMarker(ctx context.Context, cfg *SyncConfig, state *syncState, subdir string, files map[string]bool) error {
+	markerKey := objectKey(cfg.Prefix, filepath.Join(subdir, cfg.SyncMarkerFile))
+
+	keys := make([]string, 0, len(files))
+	for file := range files {
+		keys = append(keys, remoteRelPath(cfg, file))
+	}
+	sort.Strings(keys)
+	markerContent := []byte(fmt.Sprintf("Synced at: %s\nAll subdirectories verified complete.\nFiles (%d):\n%s\n",
+		time.Now().Format(time.RFC3339), len(keys), strings.Join(keys, "\n")))
+	if cfg.ManifestMode {
+		var err error
+		if markerContent, err = state.manifestFor(subdir); err != nil {
+			return err
+		}
+	}
+
+	if err := state.backend.Put(ctx, markerKey, markerContent); err != nil {
+		slog.Error("Error creating marker", "subdir", subdir, "key", markerKey, "err", err)
+		return err
+	}
+
+	slog.Debug("Created marker", "subdir", subdir, "key", markerKey)
+	return nil
+}
+
+// newSyncState gathers what a sync needs up front: the remote listing and the checksum index
+func newSyncState(ctx context.Context, backend Backend, cfg *SyncConfig, failedSubdirs *subdirSet) (*syncState, error) {
+	// List the remote prefix once so upload decisions are in-memory lookups
+	remoteFiles, err := listS3Files(ctx, backend, cfg.Prefix, cfg.SyncMarkerFile)
+	if err != nil {
+		return nil, fmt.Errorf("error listing s3://%s/%s: %w", cfg.BucketName, cfg.Prefix, err)
+	}
+	state := &syncState{
+		remoteFiles:   remoteFiles,
+		prioritized:   make(map[string]bool),
+		failedSubdirs: failedSubdirs,
+		backend:       backend,
+		dedupeKeys:    make(map[string]string),
+		manifest:      make(map[string]manifestEntry),
+	}
+
+	if cfg.ManifestMode {
+		state.oldManifest, err = loadManifests(ctx, backend, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.ChecksumIndex != "" {
+		state.checksumIndex, err = loadChecksumIndex(cfg.ChecksumIndex)
+		if err != nil {
+			return nil, &configError{err}
+		}
+	}
+
+	return state, nil
+}
+
+func syncDirectoryToS3(ctx context.Context, cfg *SyncConfig, state *syncState) error {
+	// Remove the completion marker so it's never present while a sync is in progress
+	successKey := objectKey(cfg.Prefix, successMarkerName)
+	if cfg.SuccessMarker && !cfg.DryRun {
+		if err := state.backend.Delete(ctx, successKey); err != nil {
+			return fmt.Errorf("error removing %s: %w", successMarkerName, err)
+		}
+	}
+
+	// Give subdirectories that failed last run a head start
+	if cfg.PrioritizeFailed {
+		if err := syncPriorityDirs(ctx, cfg, state, state.failedSubdirs.get()); err != nil {
+			return err
+		}
+	}
+
+	// Track files by subdirectory
+	subdirFiles := make(map[string]map[string]bool)
+
+	// Uploads run on a bounded pool; a failed upload stops the rest of the walk
+	pool := newUploadPool(ctx, cfg.Concurrency)
+	if cfg.AdaptiveConcurrency {
+		pool.adapt(cfg.AdaptiveErrorRate, cfg.AdaptiveWindow)
+	}
+
+	// Count the files up front so progress can show a total
+	stopProgress := func() {}
+	if state.progress != nil {
+		files, err := listFiles(cfg)
+		if err != nil {
+			return fmt.Errorf("error counting files in %s: %w", cfg.LocalDir, err)
+		}
+		stopProgress = startProgress(state.progress, state, len(files))
+	}
+
+	// Files waiting to be uploaded, sorted by cfg.UploadOrder before each flush
+	var pending []localFile
+	flush := func() error {
+		sortLocalFiles(pending, cfg.UploadOrder)
+		for _, f := range pending {
+			// Blocks for a free worker and fails once shutdown was requested or an upload failed
+			err := pool.Go(func(ctx context.Context) error {
+				err := uploadIfNeeded(ctx, cfg, state, &f)
+				state.countProcessed()
+				return state.tolerate(ctx, cfg, f.path, err)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	// First phase: Upload all new files and track them by subdirectory
+	filter := newWalkFilter(cfg)
+	err := walkLocalDir(cfg, func(path, relativePath string, info os.FileInfo, err error) error {
+		// Unreadable files and directories are skipped with continue_on_error
+		if err != nil {
+			return state.tolerate(ctx, cfg, path, err)
+		}
+
+		// Skip directories and anything filtered out of the sync
+		if include, err := filter.include(relativePath, info); !include {
+			return err
+		}
+
+		// Get subdirectory
+		subdir := filepath.Dir(relativePath)
+		subdir = strings.ReplaceAll(subdir, "\\", "/")
+
+		// Initialize subdir tracking if needed
+		if _, exists := subdirFiles[subdir]; !exists {
+			subdirFiles[subdir] = make(map[string]bool)
+		}
+		subdirFiles[subdir][relativePath] = true
+
+		// Queue the file; flush immediately for path order, otherwise once a chunk fills up
+		pending = append(pending, localFile{path: path, relPath: relativePath, info: info})
+		if cfg.UploadOrder == orderPath || (cfg.UploadOrderChunk > 0 && len(pending) >= cfg.UploadOrderChunk) {
+			return flush()
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	// Let in-flight uploads finish before verifying; their failure wins over a walk error
+	if poolErr := pool.Wait(); poolErr != nil {
+		err = poolErr
+	}
+	stopProgress()
+	if err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		// Nothing was uploaded, so verification and markers would only report missing files.
+		// Assume every subdirectory would verify and get a marker.
+		if cfg.WriteMarkers {
+			state.markers = len(subdirFiles)
+			if _, hasRoot := subdirFiles["."]; hasRoot {
+				state.markers--
+			}
+		}
+		slog.Info("[dry-run] Summary", "would_upload", state.uploaded, "skipped", state.skipped)
+		slog.Info("[dry-run] Skipping verification and marker files")
+		return nil
+	}
+	slog.Info("Upload pass complete", "uploaded", state.uploaded, "skipped", state.skipped)
+
+	// Second phase: Verify all subdirectories against a single fresh listing,
+	// which S3 guarantees includes everything uploaded above. Files missing from it
+	// are re-checked for stores that don't.
+	uploadedFiles, err := listS3Files(ctx, state.backend, cfg.Prefix, cfg.SyncMarkerFile)
+	if err != nil {
+		return fmt.Errorf("error listing s3://%s/%s for verification: %w", cfg.BucketName, cfg.Prefix, err)
+	}
+
+	allSubdirsComplete := true
+	rootComplete := true
+	subdirStatus := make(map[string]bool)
+
+	for subdir, localSubdirFiles := range subdirFiles {
+		// Skip root directory unless the success marker needs it verified
+		if subdir == "." && !cfg.SuccessMarker {
+			continue
+		}
+
+		// Check if all files in this subdirectory exist in S3
+		allFilesExist := true
+		for file := range localSubdirFiles {
+			if _, exists := uploadedFiles[remoteRelPath(cfg, file)]; !exists && !awaitObject(ctx, state.backend, cfg, file) {
+				allFilesExist = false
+				slog.Warn("File missing in S3", "subdir", subdir, "path", file)
+				break
+			}
+		}
+
+		if subdir == "." {
+			rootComplete = allFilesExist
+			if !allFilesExist {
+				slog.Warn("Root directory is not fully synced")
+			}
+			continue
+		}
+
+		subdirStatus[subdir] = allFilesExist
+		if !allFilesExist {
+			allSubdirsComplete = false
+			slog.Warn("Subdirectory is not fully synced", "subdir", subdir)
+		}
+	}
+
+	// Remember incomplete subdirectories so the next run can prioritize them
+	var incomplete []string
+	for subdir, isComplete := range subdirStatus {
+		if !isComplete {
+			incomplete = append(incomplete, subdir)
+		}
+	}
+	if !rootComplete {
+		incomplete = append(incomplete, ".")
+	}
+	sort.Strings(incomplete)
+	state.failedSubdirs.set(incomplete)
+
+	// Third phase: Create marker files only if all subdirectories are synced
+	if !cfg.WriteMarkers {
+		slog.Debug("Skipping marker files (write_markers=false)")
+	} else if allSubdirsComplete {
+		slog.Info("All subdirectories are fully synced, creating marker files")
+
+		// Write markers through a bounded pool; the first failure is returned
+		sem := make(chan struct{}, cfg.MarkerConcurrency)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var markerErr error
+
+		for subdir, files := range subdirFiles {
+			// Skip root directory
+			if subdir == "." {
+				continue
+			}
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				err := writeMarker(ctx, cfg, state, subdir, files)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					if markerErr == nil {
+						markerErr = err
+					}
+					return
+				}
+				state.markers++
+			}()
+		}
+		wg.Wait()
+
+		if markerErr != nil {
+			return markerErr
+		}
+		slog.Info("All marker files created successfully")
+	} else {
+		slog.Warn("Some subdirectories are not fully synced, skipping all marker files")
+		// Log details about incomplete directories
+		for subdir, isComplete := range subdirStatus {
+			if !isComplete {
+				slog.Warn("Incomplete sync", "subdir", subdir)
+			}
+		}
+	}
+
+	// Fourth phase: Mark the whole tree complete for downstream consumers
+	if cfg.SuccessMarker {
+		if !allSubdirsComplete || !rootComplete {
+			slog.Warn("Tree is not fully synced, skipping success marker")
+			return nil
+		}
+
+		if err := state.backend.Put(ctx, successKey, nil); err != nil {
+			slog.Error("Error creating success marker", "key", successKey, "err", err)
+			return err
+		}
+		slog.Info("Created success marker", "key", successKey)
+	}
+
+	return nil
+}
+
+// performFullSync runs one sync, throttling uploads with bandwidth when max_bandwidth
+// is set. The result covers whatever was done before a failure.
+func performFullSync(ctx context.Context, backend Backend, cfg *SyncConfig, failedSubdirs *subdirSet, bandwidth *rate.Limiter, progress io.Writer, onDownload func(localPath string)) (result SyncResult, err error) {
+	startedAt := time.Now()
+	// Runs last, once result has been filled in, so failures before the sync
+	// starts are counted too
+	defer func() {
+		recordMetrics(result, err)
+		writeReport(cfg, startedAt, result, err)
+	}()
+	// Capture this run's log so it can be shipped to S3 afterwards
+	if cfg.LogToS3Prefix != "" && !cfg.DryRun {
+		RunLog.startCapture()
+		defer func() {
+			// Ship the log and summary even if the run was interrupted by shutdown
+			uploadRunLog(context.WithoutCancel(ctx), backend, cfg, startedAt, RunLog.stopCapture(), result, err)
+		}()
+	}
+
+	slog.Info("Starting full directory sync", "direction", cfg.Direction)
+
+	// The volume may have been unmounted since startup
+	if err := checkLocalDir(cfg); err != nil {
+		return SyncResult{Duration: time.Since(startedAt)}, err
+	}
+
+	state, err := newSyncState(ctx, backend, cfg, failedSubdirs)
+	if err != nil {
+		return SyncResult{Duration: time.Since(startedAt)}, fmt.Errorf("error preparing sync: %w", err)
+	}
+	defer func() {
+		result = state.result(time.Since(startedAt))
+	}()
+	state.progress = progress
+	state.onDownload = onDownload
+	if cfg.MaxBandwidth > 0 {
+		state.bandwidth = bandwidth
+	}
+
+	// Bring down remote changes first so the upload pass sees them as in sync
+	if cfg.Direction != directionUp {
+		if err := downloadFromS3(ctx, cfg, state); err != nil {
+			return result, fmt.Errorf("error downloading from S3: %w", err)
+		}
+	}
+
+	// Renamed files are copied from their old key during the upload pass. With
+	// direction=both the old key would have been downloaded already.
+	if cfg.DetectRenames && cfg.Direction == directionUp {
+		if state.renames, err = findRenames(cfg, state); err != nil {
+			return result, fmt.Errorf("error detecting renamed files: %w", err)
+		}
+		if len(state.renames) > 0 {
+			slog.Info("Detected renamed files", "count", len(state.renames))
+		}
+	}
+
+	// Sync local files to S3, then deal with objects whose local file is gone
+	if cfg.Direction != directionDown {
+		err = syncDirectoryToS3(ctx, cfg, state)
+		if err != nil {
+			return result, fmt.Errorf("error syncing directory: %w", err)
+		}
+		if err := deleteRemoved(ctx, cfg, state); err != nil {
+			return result, fmt.Errorf("error deleting removed files: %w", err)
+		}
+	}
+
+	if len(state.failures) > 0 {
+		return result, &partialError{fmt.Errorf("%d files failed to sync: %w", len(state.failures), errors.Join(state.failures...))}
+	}
+
+	slog.Info("Full sync completed successfully")
+	return result, nil
+}
@@ -0,0 +1,279 @@
+package syncd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// testConfig parses a config for dir and the fake bucket, with settings on top
+func testConfig(t *testing.T, dir string, settings map[string]string) *SyncConfig {
+	t.Helper()
+	configMap := map[string]string{
+		"local_dir":   dir,
+		"bucket_name": "bucket",
+		"prefix":      "data",
+	}
+	for key, value := range settings {
+		configMap[key] = value
+	}
+	cfg, err := parseConfig(configMap)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	return cfg
+}
+
+// writeFiles creates files under dir from a relative path -> content map
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for relPath, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNeedsUpload(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		remote   string            // object body, "" for no object
+		metadata map[string]string // object metadata
+		mtime    time.Time
+		want     bool
+	}{
+		{"missing object", nil, "", nil, past, true},
+		{"exists ignores content", map[string]string{"compare": "exists"}, "other", nil, past, false},
+		{"size match", map[string]string{"compare": "size"}, "hullo", nil, past, false},
+		{"size mismatch", map[string]string{"compare": "size"}, "hello world", nil, past, true},
+		{"etag match", map[string]string{"compare": "etag"}, "hello", nil, past, false},
+		{"etag mismatch", map[string]string{"compare": "etag"}, "hullo", nil, past, true},
+		{"etag with sse-kms uses mtime", map[string]string{"compare": "etag", "sse": "aws:kms"}, "hullo", map[string]string{mtimeMetadataKey: formatMtime(past)}, past, false},
+		{"etag with sse-kms, mtime mismatch", map[string]string{"compare": "etag", "sse": "aws:kms"}, "hello", map[string]string{mtimeMetadataKey: formatMtime(future)}, past, true},
+		{"mtime match", map[string]string{"compare": "mtime"}, "hello", map[string]string{mtimeMetadataKey: formatMtime(past)}, past, false},
+		{"mtime mismatch", map[string]string{"compare": "mtime"}, "hello", map[string]string{mtimeMetadataKey: formatMtime(future)}, past, true},
+		{"mtime without metadata", map[string]string{"compare": "mtime"}, "hello", nil, past, false},
+		{"checksum mismatch", map[string]string{"compare": "checksum"}, "hello", map[string]string{sha256MetadataKey: "0"}, past, true},
+		{"checksum without metadata", map[string]string{"compare": "checksum"}, "hello", nil, past, true},
+		{"overwrite never", map[string]string{"overwrite": "never"}, "other", nil, past, false},
+		{"overwrite always", map[string]string{"overwrite": "always"}, "hello", nil, past, true},
+		{"overwrite if-newer, older file", map[string]string{"overwrite": "if-newer"}, "other", nil, past, false},
+		{"overwrite if-newer, newer file", map[string]string{"overwrite": "if-newer"}, "hello", nil, future, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+			path := filepath.Join(dir, "a.txt")
+			if err := os.Chtimes(path, tt.mtime, tt.mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			client := newFakeS3()
+			if tt.remote != "" {
+				client.put("data/a.txt", []byte(tt.remote), tt.metadata)
+			}
+			cfg := testConfig(t, dir, tt.settings)
+			state, err := newSyncState(ctx, NewS3Backend(client, cfg), cfg, &subdirSet{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := needsUpload(ctx, cfg, state, "data/a.txt", &localFile{path: path, relPath: "a.txt", info: info})
+			if err != nil {
+				t.Fatalf("needsUpload: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("needsUpload = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsUploadAfterSync(t *testing.T) {
+	// Every compare mode must see a file it just uploaded as unchanged
+	for _, compare := range []string{"exists", "size", "etag", "mtime", "checksum"} {
+		t.Run(compare, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"sub/a.txt": "hello"})
+			client := newFakeS3()
+			cfg := testConfig(t, dir, map[string]string{"compare": compare})
+
+			if _, err := performFullSync(context.Background(), NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil); err != nil {
+				t.Fatalf("first sync: %v", err)
+			}
+			result, err := performFullSync(context.Background(), NewS3Backend(client, cfg), cfg, &subdirSet{}, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("second sync: %v", err)
+			}
+			if result.FilesUploaded != 0 || result.FilesSkipped != 1 {
+				t.Errorf("second sync uploaded %d, skipped %d; want 0 and 1", result.FilesUploaded, result.FilesSkipped)
+			}
+		})
+	}
+}
+
+func TestVerification(t *testing.T) {
+	tests := []struct {
+		name       string
+		unlisted   bool // b.txt is left out of listings
+		settings   map[string]string
+		wantMarker bool
+		wantFailed []string
+	}{
+		{"all listed", false, nil, true, nil},
+		{"missing from listing", true, map[string]string{"verify_retries": "0"}, false, []string{"sub"}},
+		{"found on re-check", true, map[string]string{"verify_retries": "1", "verify_delay": "1ms"}, true, nil},
+		{"markers off", false, map[string]string{"write_markers": "false"}, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"sub/a.txt": "a", "sub/b.txt": "b"})
+			client := newFakeS3()
+			client.unlisted["data/sub/b.txt"] = tt.unlisted
+			cfg := testConfig(t, dir, tt.settings)
+
+			failed := &subdirSet{}
+			result, err := performFullSync(context.Background(), NewS3Backend(client, cfg), cfg, failed, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("performFullSync: %v", err)
+			}
+			if result.FilesUploaded != 2 {
+				t.Errorf("uploaded %d files, want 2", result.FilesUploaded)
+			}
+			if hasMarker := client.object("data/sub/syncd.txt") != nil; hasMarker != tt.wantMarker {
+				t.Errorf("marker written = %v, want %v", hasMarker, tt.wantMarker)
+			}
+			if got := failed.get(); !slices.Equal(got, tt.wantFailed) {
+				t.Errorf("failed subdirs = %v, want %v", got, tt.wantFailed)
+			}
+		})
+	}
+}
+
+// httpError is a failed response with the given status, as the SDK returns it
+func httpError(status int, err error) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      err,
+	}
+}
+
+func TestHeadS3Object(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error // returned by every HEAD, nil for none
+		exists    bool  // whether the object is stored
+		wantHeads int
+		wantErr   bool
+		wantFatal bool
+		wantFound bool
+	}{
+		{name: "found", exists: true, wantHeads: 1, wantFound: true},
+		{name: "not found", wantHeads: 1},
+		{name: "no such key", err: &types.NoSuchKey{}, wantHeads: 1},
+		{
+			name:      "access denied is fatal and not retried",
+			err:       httpError(403, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}),
+			wantHeads: 1,
+			wantErr:   true,
+			wantFatal: true,
+		},
+		{
+			name:      "5xx is retried",
+			err:       httpError(503, &smithy.GenericAPIError{Code: "ServiceUnavailable"}),
+			wantHeads: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "500 without an error code is retried",
+			err:       httpError(500, errors.New("internal error")),
+			wantHeads: 3,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeS3()
+			if tt.exists {
+				client.put("data/a.txt", []byte("hello"), nil)
+			}
+			client.headErr = func(string) error { return tt.err }
+			cfg := testConfig(t, t.TempDir(), map[string]string{"max_retries": "2"})
+
+			head, err := headS3Object(context.Background(), client, cfg, "data/a.txt")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("headS3Object error = %v, want error %v", err, tt.wantErr)
+			}
+			if fatal := IsFatal(err); fatal != tt.wantFatal {
+				t.Errorf("IsFatal(%v) = %v, want %v", err, fatal, tt.wantFatal)
+			}
+			if (head != nil) != tt.wantFound {
+				t.Errorf("headS3Object found = %v, want %v", head != nil, tt.wantFound)
+			}
+			if client.heads != tt.wantHeads {
+				t.Errorf("made %d HEAD requests, want %d", client.heads, tt.wantHeads)
+			}
+		})
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "  plain  ", want: "plain"},
+		{raw: "value # comment", want: "value"},
+		{raw: "value\t# comment", want: "value"},
+		{raw: "a#b", want: "a#b"},
+		{raw: "a=b=c", want: "a=b=c"},
+		{raw: `"a=b # not a comment"`, want: "a=b # not a comment"},
+		{raw: `  " padded "  `, want: " padded "},
+		{raw: `"quoted" # comment`, want: "quoted"},
+		{raw: `"say \"hi\" \\ bye"`, want: `say "hi" \ bye`},
+		{raw: `""`, want: ""},
+		{raw: `"unterminated`, wantErr: true},
+		{raw: `"escaped end\"`, wantErr: true},
+		{raw: `"quoted" trailing`, wantErr: true},
+		{raw: `"quoted"# comment`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseConfigValue(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseConfigValue(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseConfigValue(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package syncd
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Syncer syncs a local directory with an S3 prefix as described by a SyncConfig.
+// It remembers which subdirectories failed verification so later runs can
+// prioritize them (prioritize_failed). A Syncer must not run two syncs at once.
+type Syncer struct {
+	client        S3API
+	cfg           atomic.Pointer[SyncConfig]
+	failedSubdirs subdirSet
+	progress      io.Writer
+	backend       Backend // nil for the S3 bucket in the config
+	onDownload    func(localPath string)
+	bandwidth     *rate.Limiter // max_bandwidth, shared by all of this Syncer's uploads
+}
+
+// SyncResult summarizes a sync. In dry-run mode the counts are what would have happened.
+type SyncResult struct {
+	FilesUploaded int
+	FilesDeleted  int
+	FilesSkipped  int
+	BytesUploaded int64
+	Errors        []error // failures that didn't stop the sync
+	Duration      time.Duration
+}
+
+// NewSyncer returns a Syncer that uses client for all S3 access
+func NewSyncer(client S3API, cfg *SyncConfig) *Syncer {
+	s := &Syncer{client: client, bandwidth: newBandwidthLimiter(cfg.MaxBandwidth)}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Config returns the config the next sync will use
+func (s *Syncer) Config() *SyncConfig {
+	return s.cfg.Load()
+}
+
+// SetConfig replaces the config for later syncs; a sync already running keeps its
+// config, except that a new max_bandwidth applies to its uploads right away. The S3
+// client isn't rebuilt, see RetainRestartOnly.
+func (s *Syncer) SetConfig(cfg *SyncConfig) {
+	setBandwidth(s.bandwidth, cfg.MaxBandwidth)
+	s.cfg.Store(cfg)
+}
+
+// SetProgress makes later syncs draw a single updating progress line for the
+// upload pass on w, typically os.Stderr. Pass nil to turn it off. Call it before
+// syncing, not while a sync runs.
+func (s *Syncer) SetProgress(w io.Writer) {
+	s.progress = w
+}
+
+// SetBackend makes everything the Syncer does later use backend instead of the
+// config's S3 bucket, for instance a NewLocalBackend in tests. Call it before
+// syncing, not while a sync runs.
+func (s *Syncer) SetBackend(backend Backend) {
+	s.backend = backend
+}
+
+// SetDownloadHook makes later syncs call hook with the local path of every file
+// they download, once it is in place, e.g. so a watcher can tell syncd's own
+// writes from local changes. Pass nil to turn it off. Call it before syncing, not
+// while a sync runs.
+func (s *Syncer) SetDownloadHook(hook func(localPath string)) {
+	s.onDownload = hook
+}
+
+// storage returns the Backend for a sync with cfg
+func (s *Syncer) storage(cfg *SyncConfig) Backend {
+	if s.backend != nil {
+		return s.backend
+	}
+	return NewS3Backend(s.client, cfg)
+}
+
+// Sync runs one full sync. The result holds whatever was done before a failure.
+func (s *Syncer) Sync(ctx context.Context) (SyncResult, error) {
+	cfg := s.cfg.Load()
+	return performFullSync(ctx, s.storage(cfg), cfg, &s.failedSubdirs, s.bandwidth, s.progress, s.onDownload)
+}
+
+// Plan runs a read-only dry-run sync and writes the API calls, bytes and rough
+// cost it would involve to w
+func (s *Syncer) Plan(ctx context.Context, w io.Writer) error {
+	cfg := s.cfg.Load()
+	return planSync(ctx, s.storage(cfg), cfg, &s.failedSubdirs, w)
+}
+
+// Diff reports which files a sync would upload or re-upload and which objects
+// have no local file, without modifying anything
+func (s *Syncer) Diff(ctx context.Context) (*DiffReport, error) {
+	cfg := s.cfg.Load()
+	return diffSync(ctx, s.storage(cfg), cfg, &s.failedSubdirs)
+}
+
+// Pull downloads every object under the prefix into LocalDir, skipping files that
+// already match, without uploading or deleting anything
+func (s *Syncer) Pull(ctx context.Context) error {
+	cfg := s.cfg.Load()
+	return pullFromS3(ctx, s.storage(cfg), cfg)
+}
+
+// DeleteFromFile deletes the newline-separated relative paths listed in listPath
+// from under the configured prefix
+func (s *Syncer) DeleteFromFile(ctx context.Context, listPath string) error {
+	cfg := s.cfg.Load()
+	return deleteFromFile(ctx, s.storage(cfg), cfg, listPath)
+}
+
+// AbortStaleMultiparts aborts incomplete multipart uploads under the prefix older
+// than abort_stale_multiparts
+func (s *Syncer) AbortStaleMultiparts(ctx context.Context) error {
+	cfg := s.cfg.Load()
+	return s.storage(cfg).AbortStaleUploads(ctx, cfg.AbortStaleMultiparts)
+}
@@ -0,0 +1,98 @@
+package syncd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// sizeUnits maps the units of max_bandwidth and the file size limits to bytes
+var sizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+}
+
+// maxThrottledRead caps each throttled read so the limiter paces uploads smoothly
+const maxThrottledRead = 64 << 10
+
+// parseBandwidth parses a rate such as "10MB/s" or "512KiB/s" into bytes per second
+func parseBandwidth(value string) (int64, error) {
+	return parseSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+}
+
+// parseSize parses a size such as "1GB", "512KiB" or "1024" into bytes
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRightFunc(value, func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	})
+	multiplier, ok := sizeUnits[value[len(number):]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q (expected B, KB, MB, GB, KiB, MiB or GiB)", value)
+	}
+	amount, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	size := int64(amount * multiplier)
+	if size < 1 {
+		return 0, fmt.Errorf("size %q is below 1 byte", value)
+	}
+	return size, nil
+}
+
+// newBandwidthLimiter returns the limiter for max_bandwidth bytesPerSecond, or an
+// unlimited one when bytesPerSecond is 0
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	limiter := rate.NewLimiter(rate.Inf, maxThrottledRead)
+	if bytesPerSecond > 0 {
+		limiter.SetBurst(int(min(bytesPerSecond, maxThrottledRead)))
+	}
+	setBandwidth(limiter, bytesPerSecond)
+	return limiter
+}
+
+// setBandwidth changes limiter to bytesPerSecond, or lifts the limit when it's 0.
+// The burst is left alone so reads already sized by it stay within it.
+func setBandwidth(limiter *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond == 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// throttledReader is an upload body that waits on a shared limiter for every
+// chunk it reads, keeping aggregate throughput under the limit
+type throttledReader struct {
+	ctx     context.Context
+	body    io.ReadSeeker
+	limiter *rate.Limiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.body.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	return r.body.Seek(offset, whence)
+}
@@ -0,0 +1,29 @@
+package syncd
+
+import (
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestSyncerBandwidthLimiter(t *testing.T) {
+	cfg := testConfig(t, t.TempDir(), map[string]string{"max_bandwidth": "1MiB/s"})
+	first, second := NewSyncer(nil, cfg), NewSyncer(nil, cfg)
+	if first.bandwidth == second.bandwidth {
+		t.Error("Syncers with the same max_bandwidth share a limiter")
+	}
+	if got := first.bandwidth.Limit(); got != 1<<20 {
+		t.Errorf("limit = %v, want %v", got, 1<<20)
+	}
+
+	// A reload changes the rate of the same limiter instead of adding one
+	limiter := first.bandwidth
+	first.SetConfig(testConfig(t, t.TempDir(), map[string]string{"max_bandwidth": "2MiB/s"}))
+	if first.bandwidth != limiter || limiter.Limit() != 2<<20 {
+		t.Errorf("after reload limiter changed or limit = %v, want %v", limiter.Limit(), 2<<20)
+	}
+	first.SetConfig(testConfig(t, t.TempDir(), nil))
+	if limiter.Limit() != rate.Inf {
+		t.Errorf("after removing max_bandwidth limit = %v, want unlimited", limiter.Limit())
+	}
+}
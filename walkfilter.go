@@ -0,0 +1,274 @@
+package syncd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pathDepth returns how many directories below the walk root a relative path sits.
+// Root-level entries have depth 0.
+func pathDepth(relPath string) int {
+	return strings.Count(relPath, "/")
+}
+
+// exceedsMaxDepth reports whether a directory lies deeper than maxDepth allows.
+// Files directly inside a directory at maxDepth are still included.
+func exceedsMaxDepth(relDir string, maxDepth int) bool {
+	return maxDepth >= 0 && relDir != "." && pathDepth(relDir) >= maxDepth
+}
+
+// specialFileModes are non-regular file types that can't be uploaded; opening them may hang
+const specialFileModes = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice | os.ModeCharDevice | os.ModeIrregular
+
+// Symlink policies for the walks of LocalDir
+const (
+	symlinksSkip   = "skip"   // leave symlinks out of the sync
+	symlinksFollow = "follow" // sync the file or directory a symlink points to
+	symlinksError  = "error"  // fail the sync on any symlink
+)
+
+// walkFilter applies the filters shared by every walk of LocalDir (the upload
+// walk, listFiles and the prioritize_failed pass) so they always agree on which
+// files belong to the sync. Create one per walk; it caches .gitignore rules.
+type walkFilter struct {
+	cfg        *SyncConfig
+	gitignores map[string][]gitignoreRule // directory relative path -> rules from its .gitignore
+	quiet      bool                       // don't log files skipped for their size
+}
+
+func newWalkFilter(cfg *SyncConfig) *walkFilter {
+	return &walkFilter{
+		cfg:        cfg,
+		gitignores: make(map[string][]gitignoreRule),
+	}
+}
+
+// include reports whether a walked entry should sync. It returns
+// filepath.SkipDir to prune directories and false for files that must not sync.
+func (w *walkFilter) include(relPath string, info os.FileInfo) (bool, error) {
+	cfg := w.cfg
+
+	if info.IsDir() {
+		if w.prunedDir(relPath) {
+			return false, filepath.SkipDir
+		}
+		return false, nil
+	}
+
+	// The instance lock belongs to this machine, not the sync. Local files named
+	// like the marker would collide with it and never be seen as synced. Sidecars
+	// are applied to the file they describe instead of being uploaded.
+	if relPath == LockFileName || filepath.Base(relPath) == cfg.SyncMarkerFile || strings.HasSuffix(relPath, sidecarSuffix) {
+		return false, nil
+	}
+
+	if w.filteredOut(relPath) {
+		return false, nil
+	}
+
+	if info.Mode().IsRegular() && outsideSizeLimits(cfg, info.Size()) {
+		if !w.quiet {
+			slog.Warn("Skipping file outside max_file_size/min_file_size", "path", relPath, "size", info.Size())
+		}
+		return false, nil
+	}
+
+	if info.Mode()&specialFileModes != 0 {
+		if cfg.OnSpecialFile == "fail" {
+			return false, fmt.Errorf("special file %s (%s) found in local directory", relPath, info.Mode().Type())
+		}
+		slog.Warn("Skipping special file", "path", relPath, "type", info.Mode().Type())
+		return false, nil
+	}
+
+	// With symlinks=follow the walk has already replaced links with their targets
+	if info.Mode()&os.ModeSymlink != 0 {
+		if cfg.Symlinks == symlinksError {
+			return false, fmt.Errorf("symlink %s found in local directory", relPath)
+		}
+		slog.Debug("Skipping symlink", "path", relPath)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// prunedDir reports whether a directory is left out of the walk, with everything
+// below it: nested deeper than max_depth, excluded or ignored
+func (w *walkFilter) prunedDir(relDir string) bool {
+	if exceedsMaxDepth(relDir, w.cfg.MaxDepth) {
+		return true
+	}
+	if relDir != "." && matchAny(w.cfg.Exclude, relDir+"/") {
+		return true
+	}
+	return relDir != "." && w.cfg.RespectGitignore && w.gitignored(relDir, true)
+}
+
+// filteredOut reports whether a file path is left out by exclude, .gitignore or include
+func (w *walkFilter) filteredOut(relPath string) bool {
+	// Excludes take precedence over includes
+	if matchAny(w.cfg.Exclude, relPath) {
+		return true
+	}
+	if w.cfg.RespectGitignore && w.gitignored(relPath, false) {
+		return true
+	}
+	return len(w.cfg.Include) > 0 && !matchAny(w.cfg.Include, relPath)
+}
+
+// outOfScope reports whether a file path, relative to LocalDir, is deliberately left
+// out of the sync, so a remote copy with no local file must not be deleted: it lies
+// in a pruned directory, is filtered out or skipped for its size, or the local entry
+// exists but isn't a file the walk syncs (special files, skipped symlinks)
+func (w *walkFilter) outOfScope(relPath string) bool {
+	if dir := path.Dir(relPath); dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			if w.prunedDir(strings.Join(parts[:i+1], "/")) {
+				return true
+			}
+		}
+	}
+	if w.filteredOut(relPath) || skippedForSize(w.cfg, relPath) {
+		return true
+	}
+
+	stat := os.Lstat
+	if w.cfg.Symlinks == symlinksFollow {
+		stat = os.Stat
+	}
+	info, err := stat(filepath.Join(w.cfg.LocalDir, filepath.FromSlash(relPath)))
+	return err == nil && !info.Mode().IsRegular()
+}
+
+// escapingSymlinkTarget resolves a symlink and returns its target if it escapes
+// LocalDir and every symlink_allowed_roots entry, or "" if the target is allowed
+func escapingSymlinkTarget(cfg *SyncConfig, linkPath string) (string, error) {
+	target, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		return "", fmt.Errorf("error resolving symlink %s: %v", linkPath, err)
+	}
+
+	for _, root := range append([]string{cfg.LocalDir}, cfg.SymlinkAllowedRoots...) {
+		resolvedRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		if withinDir(resolvedRoot, target) {
+			return "", nil
+		}
+	}
+	return target, nil
+}
+
+// withinDir reports whether p is dir or lies below it
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// followSymlink returns the info of the file or directory a symlink points to, or
+// nil if the link escapes local_dir and on_escaping_symlink says to skip it
+func followSymlink(cfg *SyncConfig, linkPath string) (os.FileInfo, error) {
+	target, err := escapingSymlinkTarget(cfg, linkPath)
+	if err != nil {
+		return nil, err
+	}
+	if target != "" {
+		if cfg.OnEscapingSymlink == "fail" {
+			return nil, fmt.Errorf("symlink %s points outside local_dir: %s", linkPath, target)
+		}
+		slog.Warn("Skipping symlink with target outside local_dir", "path", linkPath, "target", target)
+		return nil, nil
+	}
+	return os.Stat(linkPath)
+}
+
+// walkLocalDir walks LocalDir like filepath.Walk, passing each entry's path relative
+// to LocalDir with slash separators. With symlinks=follow, links are reported with
+// their target's info and linked directories are walked under the link's path.
+// Links back into a directory that is already being walked are skipped.
+func walkLocalDir(cfg *SyncConfig, fn func(path, relPath string, info os.FileInfo, err error) error) error {
+	return walkTree(cfg, cfg.LocalDir, ".", make(map[string]bool), fn)
+}
+
+// walkTree walks root, whose entries appear under relRoot. visiting holds the
+// resolved directories currently being walked.
+func walkTree(cfg *SyncConfig, root, relRoot string, visiting map[string]bool, fn func(path, relPath string, info os.FileInfo, err error) error) error {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		visiting[resolved] = true
+		defer delete(visiting, resolved)
+	}
+
+	return filepath.Walk(root, func(walkPath string, info os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(root, walkPath)
+		if relErr != nil {
+			return relErr
+		}
+		// A followed directory was already reported under the link's own path
+		if rel == "." && relRoot != "." {
+			return nil
+		}
+		relPath := path.Join(relRoot, filepath.ToSlash(rel))
+
+		if err != nil || info.Mode()&os.ModeSymlink == 0 || cfg.Symlinks != symlinksFollow {
+			return fn(walkPath, relPath, info, err)
+		}
+
+		target, err := followSymlink(cfg, walkPath)
+		if err != nil {
+			return fn(walkPath, relPath, info, err)
+		}
+		if target == nil {
+			return nil
+		}
+		if !target.IsDir() {
+			return fn(walkPath, relPath, target, nil)
+		}
+
+		resolved, err := filepath.EvalSymlinks(walkPath)
+		if err != nil {
+			return fn(walkPath, relPath, info, err)
+		}
+		parent, err := filepath.EvalSymlinks(filepath.Dir(walkPath))
+		if err != nil {
+			return fn(walkPath, relPath, info, err)
+		}
+		if visiting[resolved] || withinDir(resolved, parent) {
+			slog.Warn("Skipping symlink that would loop back into its own directory tree", "path", relPath, "target", resolved)
+			return nil
+		}
+
+		if err := fn(walkPath, relPath, target, nil); err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+		return walkTree(cfg, resolved, relPath, visiting, fn)
+	})
+}
+
+// outsideSizeLimits reports whether a file of size bytes is skipped by max_file_size or min_file_size
+func outsideSizeLimits(cfg *SyncConfig, size int64) bool {
+	return (cfg.MaxFileSize > 0 && size > cfg.MaxFileSize) || size < cfg.MinFileSize
+}
+
+// skippedForSize reports whether relPath is a local file left out of the sync by its
+// size, whose remote copy must therefore be kept
+func skippedForSize(cfg *SyncConfig, relPath string) bool {
+	if cfg.MaxFileSize == 0 && cfg.MinFileSize == 0 {
+		return false
+	}
+	stat := os.Lstat
+	if cfg.Symlinks == symlinksFollow {
+		stat = os.Stat
+	}
+	info, err := stat(filepath.Join(cfg.LocalDir, filepath.FromSlash(relPath)))
+	return err == nil && info.Mode().IsRegular() && outsideSizeLimits(cfg, info.Size())
+}